@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	providersAPIPath = "/local-router/api/providers"
+
+	// maxAdminBody bounds the provider definitions accepted by the admin API.
+	maxAdminBody = 1 << 20
+)
+
+// AdminConfig protects the admin API.
+type AdminConfig struct {
+	// Token is the bearer token required by the provider endpoints, which
+	// are disabled without one. Like provider secrets it may be a
+	// ${ENV:NAME}, file: or cmd: reference.
+	Token string `yaml:"token"`
+}
+
+func (a *AdminConfig) validate(providers []Provider) []string {
+	if a.Token == "" {
+		return []string{"token cannot be empty"}
+	}
+	var problems []string
+	for i := range providers {
+		if providers[i].Secret == a.Token {
+			problems = append(problems, fmt.Sprintf("token must differ from the secret of provider %s", providers[i].Name))
+		}
+	}
+	return problems
+}
+
+func (p *Provider) enabled() bool {
+	return p.Enabled == nil || *p.Enabled
+}
+
+func (s *Server) adminToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.config.Admin == nil {
+		return ""
+	}
+	return s.config.Admin.Token
+}
+
+// requireAdmin rejects requests without the admin bearer token.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.adminToken()
+		if token == "" {
+			writeOpenAIError(w, http.StatusForbidden, "permission_error", "admin_disabled", "The admin API is disabled, set admin.token to enable it")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="local-router admin"`)
+			writeOpenAIError(w, http.StatusUnauthorized, "authentication_error", "invalid_admin_token", "Invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminError is a failed admin request, reported with its HTTP status.
+type adminError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *adminError) Error() string {
+	return e.message
+}
+
+// ProviderView is a provider as reported by the admin API. Secrets are never
+// included.
+type ProviderView struct {
+	Name            string   `json:"name"`
+	Type            string   `json:"type,omitempty"`
+	URL             string   `json:"url,omitempty"`
+	Models          []string `json:"models"`
+	DiscoverModels  bool     `json:"discoverModels,omitempty"`
+	Enabled         bool     `json:"enabled"`
+	ConcurrentLimit int      `json:"concurrentLimit,omitempty"`
+	Source          string   `json:"source,omitempty"`
+}
+
+func newProviderView(p *Provider) ProviderView {
+	models := append([]string{}, p.Models...)
+	return ProviderView{
+		Name:            p.Name,
+		Type:            p.Type,
+		URL:             p.URL,
+		Models:          models,
+		DiscoverModels:  p.DiscoverModels,
+		Enabled:         p.enabled(),
+		ConcurrentLimit: p.ConcurrentLimit,
+		Source:          p.source,
+	}
+}
+
+// ProvidersHandler serves the provider admin API:
+//
+//	GET    /local-router/api/providers          list providers
+//	POST   /local-router/api/providers          add a provider
+//	GET    /local-router/api/providers/{name}   show a provider
+//	PUT    /local-router/api/providers/{name}   replace a provider's settings
+//	PATCH  /local-router/api/providers/{name}   change some settings, null removes one
+//	DELETE /local-router/api/providers/{name}   remove a provider
+//
+// Definitions are sent as JSON or YAML objects with the keys of the config
+// file. Changes are validated like a reload and written back to the file
+// holding the provider, keeping its comments, before they take effect.
+func (s *Server) ProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, providersAPIPath), "/")
+
+	var err error
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		s.mu.RLock()
+		views := make([]ProviderView, 0, len(s.config.Providers))
+		for i := range s.config.Providers {
+			views = append(views, newProviderView(&s.config.Providers[i]))
+		}
+		s.mu.RUnlock()
+		writeStatusJSON(w, map[string]interface{}{"providers": views})
+		return
+	case name == "" && r.Method == http.MethodPost:
+		err = s.addProvider(w, r)
+	case name == "":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	case r.Method == http.MethodGet:
+		err = s.showProvider(w, name)
+	case r.Method == http.MethodPut, r.Method == http.MethodPatch:
+		err = s.updateProvider(w, r, name, r.Method == http.MethodPatch)
+	case r.Method == http.MethodDelete:
+		err = s.deleteProvider(w, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err == nil {
+		return
+	}
+
+	var adminErr *adminError
+	switch {
+	case errors.As(err, &adminErr):
+		writeOpenAIError(w, adminErr.status, openAIErrorType(adminErr.status), adminErr.code, adminErr.message)
+	case errors.Is(err, ErrInvalidConfig):
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_config", err.Error())
+	default:
+		Log(ComponentConfig).Error("failed to update providers", "method", r.Method, "provider", name, "error", err)
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "config_update_failed", err.Error())
+	}
+}
+
+func (s *Server) showProvider(w http.ResponseWriter, name string) error {
+	s.mu.RLock()
+	provider := s.config.findProviderByName(name)
+	var view ProviderView
+	if provider != nil {
+		view = newProviderView(provider)
+	}
+	s.mu.RUnlock()
+	if provider == nil {
+		return providerNotFound(name)
+	}
+	writeStatusJSON(w, view)
+	return nil
+}
+
+func (s *Server) addProvider(w http.ResponseWriter, r *http.Request) error {
+	body, err := readProviderBody(r, false)
+	if err != nil {
+		return err
+	}
+	nameNode := mappingValue(body, "name")
+	if nameNode == nil || nameNode.Value == "" {
+		return &adminError{http.StatusBadRequest, "invalid_provider", "name is required"}
+	}
+	name := nameNode.Value
+
+	config, err := s.editConfig(func(edit *configEdit, current *Config) error {
+		if current.findProviderByName(name) != nil {
+			return &adminError{http.StatusConflict, "provider_exists", fmt.Sprintf("Provider %q already exists", name)}
+		}
+		doc, err := edit.document(s.configPath)
+		if err != nil {
+			return err
+		}
+		providers, err := doc.providers()
+		if err != nil {
+			return err
+		}
+		providers.Content = append(providers.Content, body)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	Log(ComponentConfig).Info("added provider", "provider", name)
+	return writeProvider(w, http.StatusCreated, config, name)
+}
+
+// updateProvider replaces the settings of a provider, or with patch only
+// those in the body. The provider is edited where its highest-precedence
+// definition lives; settings it inherits from included files still apply.
+func (s *Server) updateProvider(w http.ResponseWriter, r *http.Request, name string, patch bool) error {
+	body, err := readProviderBody(r, patch)
+	if err != nil {
+		return err
+	}
+	if nameNode := mappingValue(body, "name"); nameNode != nil && nameNode.Value != name {
+		return &adminError{http.StatusBadRequest, "invalid_provider", "name cannot be changed"}
+	}
+
+	config, err := s.editConfig(func(edit *configEdit, current *Config) error {
+		provider := current.findProviderByName(name)
+		if provider == nil {
+			return providerNotFound(name)
+		}
+		doc, err := edit.document(provider.definitionFile(s.configPath))
+		if err != nil {
+			return err
+		}
+		entry, err := doc.findProvider(name)
+		if err != nil {
+			return err
+		}
+		if !patch {
+			for i := 0; i+1 < len(entry.Content); {
+				if key := entry.Content[i].Value; key != "name" && mappingValue(body, key) == nil {
+					entry.Content = append(entry.Content[:i], entry.Content[i+2:]...)
+					continue
+				}
+				i += 2
+			}
+		}
+		for i := 0; i+1 < len(body.Content); i += 2 {
+			key, value := body.Content[i], body.Content[i+1]
+			if value.Tag == "!!null" {
+				deleteMappingKey(entry, key.Value)
+			} else {
+				setMappingValue(entry, key, value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	Log(ComponentConfig).Info("updated provider", "provider", name, "patch", patch)
+	return writeProvider(w, http.StatusOK, config, name)
+}
+
+// deleteProvider removes every definition of a provider, including those in
+// included files.
+func (s *Server) deleteProvider(w http.ResponseWriter, name string) error {
+	_, err := s.editConfig(func(edit *configEdit, current *Config) error {
+		if current.findProviderByName(name) == nil {
+			return providerNotFound(name)
+		}
+		for _, path := range current.files {
+			doc, err := edit.document(path)
+			if err != nil {
+				return err
+			}
+			providers := mappingValue(documentContent(&doc.root), "providers")
+			if providers == nil || providers.Kind != yaml.SequenceNode {
+				continue
+			}
+			kept := providers.Content[:0]
+			for _, item := range providers.Content {
+				if nameNode := mappingValue(item, "name"); nameNode == nil || nameNode.Value != name {
+					kept = append(kept, item)
+				}
+			}
+			providers.Content = kept
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	Log(ComponentConfig).Info("deleted provider", "provider", name)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func writeProvider(w http.ResponseWriter, status int, config *Config, name string) error {
+	provider := config.findProviderByName(name)
+	if provider == nil {
+		return providerNotFound(name)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(newProviderView(provider)); err != nil {
+		Log(ComponentConfig).Error("failed to encode provider", "provider", name, "error", err)
+	}
+	return nil
+}
+
+func providerNotFound(name string) error {
+	return &adminError{http.StatusNotFound, "provider_not_found", fmt.Sprintf("Provider %q does not exist", name)}
+}
+
+// readProviderBody parses a provider definition sent as JSON or YAML. With
+// patch, null values are allowed to remove settings.
+func readProviderBody(r *http.Request, patch bool) (*yaml.Node, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBody))
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &adminError{http.StatusBadRequest, "invalid_provider", "Invalid provider definition: " + err.Error()}
+	}
+	body := documentContent(&doc)
+	if body.Kind != yaml.MappingNode {
+		return nil, &adminError{http.StatusBadRequest, "invalid_provider", "The provider definition must be an object"}
+	}
+	if errs := checkUnknownFields(body, reflect.TypeOf(Provider{}), "body"); len(errs) > 0 {
+		return nil, &adminError{http.StatusBadRequest, "invalid_provider", errors.Join(errs...).Error()}
+	}
+	var provider Provider
+	if err := body.Decode(&provider); err != nil {
+		return nil, &adminError{http.StatusBadRequest, "invalid_provider", "Invalid provider definition: " + err.Error()}
+	}
+	if !patch {
+		for i := 1; i < len(body.Content); i += 2 {
+			if body.Content[i].Tag == "!!null" {
+				return nil, &adminError{http.StatusBadRequest, "invalid_provider", fmt.Sprintf("%s cannot be null", body.Content[i-1].Value)}
+			}
+		}
+	}
+	blockStyle(body)
+	return body, nil
+}
+
+// definitionFile returns the file holding the highest-precedence definition
+// of the provider, or mainFile for providers not loaded from a file.
+func (p *Provider) definitionFile(mainFile string) string {
+	source, _, _ := strings.Cut(p.source, ", overriding ")
+	if i := strings.LastIndexByte(source, ':'); i > 0 {
+		return source[:i]
+	}
+	return mainFile
+}
+
+// configEdit collects changes to config files, which are written only if the
+// config they produce is valid.
+type configEdit struct {
+	docs map[string]*configDocument
+}
+
+// document returns the parsed file at path, reading it on first use.
+func (e *configEdit) document(path string) (*configDocument, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if doc, ok := e.docs[absPath]; ok {
+		return doc, nil
+	}
+	doc, err := readConfigDocument(absPath)
+	if err != nil {
+		return nil, err
+	}
+	e.docs[absPath] = doc
+	return doc, nil
+}
+
+// editConfig applies edit to the config files and reloads them. Edits are
+// serialized with reloads, and see the config that is currently loaded.
+func (s *Server) editConfig(edit func(*configEdit, *Config) error) (*Config, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	s.mu.RUnlock()
+
+	e := &configEdit{docs: make(map[string]*configDocument)}
+	if err := edit(e, current); err != nil {
+		return nil, err
+	}
+	pending := make(map[string][]byte, len(e.docs))
+	for path, doc := range e.docs {
+		data, err := doc.encode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		pending[path] = data
+	}
+	return s.reload("api", pending)
+}
+
+// configDocument is a config file parsed for editing. Editing the node tree
+// rather than a Config keeps comments, key order and secret references.
+type configDocument struct {
+	path string
+	root yaml.Node
+}
+
+func readConfigDocument(path string) (*configDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	doc := &configDocument{path: path}
+	if err := yaml.Unmarshal(data, &doc.root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if doc.root.Kind == 0 {
+		doc.root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	return doc, nil
+}
+
+// providers returns the providers sequence, adding one if the file has none.
+func (d *configDocument) providers() (*yaml.Node, error) {
+	top := documentContent(&d.root)
+	if top.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: the config must be a mapping", d.path)
+	}
+	providers := mappingValue(top, "providers")
+	switch {
+	case providers == nil:
+		providers = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		top.Content = append(top.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "providers"}, providers)
+	case providers.Kind == yaml.ScalarNode && providers.Tag == "!!null":
+		// "providers:" with nothing under it.
+		providers.Kind, providers.Tag, providers.Value = yaml.SequenceNode, "!!seq", ""
+	case providers.Kind != yaml.SequenceNode:
+		return nil, fmt.Errorf("%s: providers must be a list", d.path)
+	}
+	return providers, nil
+}
+
+func (d *configDocument) findProvider(name string) (*yaml.Node, error) {
+	providers, err := d.providers()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range providers.Content {
+		if nameNode := mappingValue(item, "name"); nameNode != nil && nameNode.Value == name {
+			return item, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: provider %s not found", d.path, name)
+}
+
+func (d *configDocument) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&d.root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setMappingValue sets key in a mapping node, keeping the comments of an
+// existing entry.
+func setMappingValue(mapping, key, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key.Value {
+			old := mapping.Content[i+1]
+			if value.LineComment == "" {
+				value.LineComment = old.LineComment
+			}
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, key, value)
+}
+
+func deleteMappingKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// blockStyle switches a node parsed from JSON to the block style of the
+// config file. Strings that need quotes are quoted again when encoded.
+func blockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		node.Style &^= yaml.DoubleQuotedStyle | yaml.SingleQuotedStyle
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// writeFileAtomic replaces path with data, keeping its permissions, so that
+// the config watcher never reads a partly written file.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
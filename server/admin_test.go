@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const adminTestConfig = `# router config
+port: 8080
+include: [extra.yaml]
+admin:
+  token: admin-secret
+providers:
+  # the main provider
+  - name: up
+    url: http://up.example
+    secret: sk-up   # literal for tests
+    models: [m]
+    concurrentLimit: 1
+`
+
+func adminRequest(t *testing.T, router *httptest.Server, method, path, token, body string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, router.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp, result
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestProvidersAPI(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	extraPath := filepath.Join(dir, "extra.yaml")
+	writeTestConfig(t, path, adminTestConfig)
+	writeTestConfig(t, extraPath, "providers:\n  - name: extra   # from the team\n    url: http://extra.example\n    secret: sk-extra\n    models: [e]\n")
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(config, path)
+	router := httptest.NewServer(s.SetupRoutes())
+	defer router.Close()
+	const token = "admin-secret"
+
+	t.Run("RequiresToken", func(t *testing.T) {
+		for _, bad := range []string{"", "sk-up"} {
+			if resp, _ := adminRequest(t, router, http.MethodGet, providersAPIPath, bad, ""); resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("Expected token %q to be rejected, got %d", bad, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		resp, body := adminRequest(t, router, http.MethodGet, providersAPIPath, token, "")
+		providers, _ := body["providers"].([]interface{})
+		if resp.StatusCode != http.StatusOK || len(providers) != 2 {
+			t.Fatalf("Expected two providers, got %d %v", resp.StatusCode, body)
+		}
+		if up := providers[1].(map[string]interface{}); up["name"] != "up" || up["enabled"] != true || up["secret"] != nil {
+			t.Errorf("Expected up without its secret, got %v", up)
+		}
+	})
+
+	t.Run("Add", func(t *testing.T) {
+		resp, body := adminRequest(t, router, http.MethodPost, providersAPIPath, token,
+			`{"name":"added","url":"http://added.example","secret":"sk-added","models":["a","true"]}`)
+		if resp.StatusCode != http.StatusCreated || body["name"] != "added" {
+			t.Fatalf("Expected the provider to be created, got %d %v", resp.StatusCode, body)
+		}
+		saved := readFile(t, path)
+		for _, want := range []string{"# the main provider", "# literal for tests", "  - name: added\n", `- "true"`} {
+			if !strings.Contains(saved, want) {
+				t.Errorf("Expected the config file to contain %q, got:\n%s", want, saved)
+			}
+		}
+		if s.FindProvider("[added]a") == nil {
+			t.Error("Expected the new provider to be routed")
+		}
+
+		if resp, _ := adminRequest(t, router, http.MethodPost, providersAPIPath, token, `{"name":"added","url":"http://x","secret":"s","models":["a"]}`); resp.StatusCode != http.StatusConflict {
+			t.Errorf("Expected a duplicate to conflict, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("InvalidChangesAreNotWritten", func(t *testing.T) {
+		before := readFile(t, path)
+		resp, body := adminRequest(t, router, http.MethodPost, providersAPIPath, token, `{"name":"bad","url":"ftp://bad","secret":"s","models":["b"]}`)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body["error"].(map[string]interface{})["message"].(string), "URL scheme must be http or https") {
+			t.Errorf("Expected a validation error, got %d %v", resp.StatusCode, body)
+		}
+		resp, body = adminRequest(t, router, http.MethodPatch, providersAPIPath+"/up", token, `{"concurrentLimt": 2}`)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body["error"].(map[string]interface{})["message"].(string), `unknown field "concurrentLimt"`) {
+			t.Errorf("Expected an unknown field error, got %d %v", resp.StatusCode, body)
+		}
+		if after := readFile(t, path); after != before {
+			t.Errorf("Expected the config file to be unchanged, got:\n%s", after)
+		}
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		resp, body := adminRequest(t, router, http.MethodPatch, providersAPIPath+"/up", token, `{"enabled": false, "concurrentLimit": 3}`)
+		if resp.StatusCode != http.StatusOK || body["enabled"] != false || body["concurrentLimit"] != 3.0 {
+			t.Fatalf("Expected up to be disabled with a limit of 3, got %d %v", resp.StatusCode, body)
+		}
+		saved := readFile(t, path)
+		if !strings.Contains(saved, "concurrentLimit: 3") || !strings.Contains(saved, "enabled: false") || !strings.Contains(saved, "# the main provider") {
+			t.Errorf("Expected the change to be saved with the comments, got:\n%s", saved)
+		}
+		if s.FindProvider("[up]m") != nil {
+			t.Error("Expected a disabled provider not to be routed")
+		}
+		s.mu.RLock()
+		limit := cap(s.limiters["up"])
+		s.mu.RUnlock()
+		if limit != 3 {
+			t.Errorf("Expected the new limit to apply, got %d", limit)
+		}
+
+		resp, _ = adminRequest(t, router, http.MethodPatch, providersAPIPath+"/extra", token, `{"concurrentLimit": 2}`)
+		if saved := readFile(t, extraPath); resp.StatusCode != http.StatusOK || !strings.Contains(saved, "concurrentLimit: 2") || !strings.Contains(saved, "# from the team") {
+			t.Errorf("Expected the included file to be edited, got %d:\n%s", resp.StatusCode, saved)
+		}
+		if strings.Contains(readFile(t, path), "name: extra") {
+			t.Error("Expected the main config file not to gain an entry for extra")
+		}
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		resp, body := adminRequest(t, router, http.MethodPut, providersAPIPath+"/up", token, "url: http://up2.example\nsecret: sk-up\nmodels: [m, n]\n")
+		if resp.StatusCode != http.StatusOK || body["url"] != "http://up2.example" || body["enabled"] != true || body["concurrentLimit"] != nil {
+			t.Errorf("Expected the settings to be replaced, got %d %v", resp.StatusCode, body)
+		}
+		if resp, _ := adminRequest(t, router, http.MethodPut, providersAPIPath+"/up", token, `{"name":"renamed"}`); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected a rename to be rejected, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if resp, _ := adminRequest(t, router, http.MethodDelete, providersAPIPath+"/added", token, ""); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected the provider to be deleted, got %d", resp.StatusCode)
+		}
+		if resp, _ := adminRequest(t, router, http.MethodGet, providersAPIPath+"/added", token, ""); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected the provider to be gone, got %d", resp.StatusCode)
+		}
+		if strings.Contains(readFile(t, path), "added") {
+			t.Error("Expected the provider to be removed from the config file")
+		}
+	})
+}
+
+func TestProvidersAPIDisabledWithoutToken(t *testing.T) {
+	_, router := newRouter(t, Provider{Name: "up", URL: "http://up.example", Models: []string{"m"}})
+	if resp, _ := adminRequest(t, router, http.MethodGet, providersAPIPath, "anything", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the admin API to be disabled, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminConfigValidation(t *testing.T) {
+	config := &Config{
+		Port:      8080,
+		Admin:     &AdminConfig{Token: "sk-a"},
+		Providers: []Provider{{Name: "a", URL: "http://a", Secret: "sk-a", Models: []string{"m"}}},
+	}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "admin: token must differ from the secret of provider a") {
+		t.Errorf("Expected a shared token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	ProviderTypeAzureOpenAI = "azure-openai"
+
+	defaultAzureAPIVersion   = "2024-10-21"
+	defaultAzureTokenRefresh = 45 * time.Minute
+	azureProbePath           = "/openai/models"
+	// azureTokenRetry is how long a failed token refresh waits before the
+	// next attempt.
+	azureTokenRetry = 30 * time.Second
+)
+
+// AzureConfig holds the settings of an azure-openai provider. The provider
+// URL is the resource endpoint, such as https://NAME.openai.azure.com.
+type AzureConfig struct {
+	APIVersion string `yaml:"apiVersion"`
+	// Deployments maps model names to deployment names. Models without an
+	// entry are sent to the deployment of the same name.
+	Deployments map[string]string `yaml:"deployments"`
+	// ADToken is a Microsoft Entra ID token reference in any form the
+	// secret accepts, usually cmd:. It replaces the api-key secret and is
+	// resolved again every TokenRefresh, as the tokens expire.
+	ADToken      string        `yaml:"adToken"`
+	TokenRefresh time.Duration `yaml:"tokenRefresh"`
+}
+
+func (c *AzureConfig) validate() []string {
+	var problems []string
+	for model, deployment := range c.Deployments {
+		if deployment == "" {
+			problems = append(problems, fmt.Sprintf("deployments: %s has no deployment", model))
+		}
+	}
+	if c.TokenRefresh < 0 {
+		problems = append(problems, "tokenRefresh cannot be negative")
+	}
+	return problems
+}
+
+func (p *Provider) usesAzureAD() bool {
+	return p.Type == ProviderTypeAzureOpenAI && p.Azure != nil && p.Azure.ADToken != ""
+}
+
+func (p *Provider) azureAPIVersion() string {
+	if p.Azure != nil && p.Azure.APIVersion != "" {
+		return p.Azure.APIVersion
+	}
+	return defaultAzureAPIVersion
+}
+
+func (p *Provider) azureDeployment(model string) string {
+	if p.Azure != nil {
+		if deployment, ok := p.Azure.Deployments[model]; ok {
+			return deployment
+		}
+	}
+	return model
+}
+
+// chatCompletionsURL returns the upstream URL for a chat completion with
+// model, keeping the client's query parameters.
+func (p *Provider) chatCompletionsURL(model, rawQuery string) (*url.URL, error) {
+	target, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	if p.Type != ProviderTypeAzureOpenAI {
+		target.Path += "/chat/completions"
+		target.RawQuery = rawQuery
+		return target, nil
+	}
+
+	target = target.JoinPath("openai", "deployments", p.azureDeployment(model), "chat", "completions")
+	query, _ := url.ParseQuery(rawQuery)
+	query.Set("api-version", p.azureAPIVersion())
+	target.RawQuery = query.Encode()
+	return target, nil
+}
+
+// azureTokens caches resolved AD tokens by reference, so that reloads and
+// providers sharing a reference don't run the token command again. The map
+// lock is only held to find an entry; each entry resolves on its own.
+var azureTokens = struct {
+	sync.Mutex
+	entries map[string]*azureToken
+}{entries: make(map[string]*azureToken)}
+
+// azureToken is the cached token of one reference. While a refresh runs,
+// callers get the previous token instead of waiting, and after a failed
+// refresh the previous token is kept for azureTokenRetry before the next
+// attempt.
+type azureToken struct {
+	mu       sync.Mutex
+	value    string
+	resolved time.Time
+	err      error
+	failedAt time.Time
+	// pending is closed when the running resolution ends.
+	pending chan struct{}
+}
+
+func (p *Provider) azureADToken() (string, error) {
+	refresh := p.Azure.TokenRefresh
+	if refresh <= 0 {
+		refresh = defaultAzureTokenRefresh
+	}
+
+	azureTokens.Lock()
+	token, ok := azureTokens.entries[p.Azure.ADToken]
+	if !ok {
+		token = &azureToken{}
+		azureTokens.entries[p.Azure.ADToken] = token
+	}
+	azureTokens.Unlock()
+	return token.get(p.Azure.ADToken, refresh)
+}
+
+func (t *azureToken) get(ref string, refresh time.Duration) (string, error) {
+	t.mu.Lock()
+	for t.value == "" && t.pending != nil {
+		pending := t.pending
+		t.mu.Unlock()
+		<-pending
+		t.mu.Lock()
+	}
+	fresh := t.value != "" && time.Since(t.resolved) < refresh
+	backingOff := t.err != nil && time.Since(t.failedAt) < azureTokenRetry
+	if fresh || t.pending != nil || backingOff {
+		value, err := t.value, t.err
+		t.mu.Unlock()
+		if value != "" {
+			return value, nil
+		}
+		return "", err
+	}
+	pending := make(chan struct{})
+	t.pending = pending
+	t.mu.Unlock()
+
+	value, err := resolveSecret(ref)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = nil
+	close(pending)
+	if err != nil {
+		t.err = fmt.Errorf("failed to resolve Azure AD token: %w", err)
+		t.failedAt = time.Now()
+		if t.value != "" {
+			Log(ComponentRouting).Warn("failed to refresh Azure AD token, keeping the previous one", "retry_in", azureTokenRetry, "error", err)
+			return t.value, nil
+		}
+		return "", t.err
+	}
+	t.value, t.resolved, t.err = value, time.Now(), nil
+	return value, nil
+}
+
+// translateAzureError rewrites an Azure error response into the OpenAI error
+// format. Azure leaves type null, nests content filter details under
+// innererror and sometimes sends numeric codes.
+func translateAzureError(resp *http.Response) {
+	if resp.StatusCode < 400 {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	var body struct {
+		Error map[string]interface{} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) != nil || body.Error == nil {
+		return
+	}
+
+	var code interface{}
+	if c := body.Error["code"]; c != nil {
+		code = fmt.Sprint(c)
+	}
+	if inner := getMap(body.Error, "innererror"); getString(inner, "code") == "ResponsibleAIPolicyViolation" {
+		code = "content_filter"
+	}
+	errType := getString(body.Error, "type")
+	if errType == "" {
+		errType = openAIErrorType(resp.StatusCode)
+	}
+	translated := map[string]interface{}{
+		"message": getString(body.Error, "message"),
+		"type":    errType,
+		"param":   body.Error["param"],
+		"code":    code,
+	}
+
+	data, err = json.Marshal(map[string]interface{}{"error": translated})
+	if err != nil {
+		return
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Content-Length")
+}
+
+// openAIErrorType returns the OpenAI error type for an HTTP status.
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	}
+	return "invalid_request_error"
+}
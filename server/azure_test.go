@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAzureChatCompletionsURL(t *testing.T) {
+	provider := &Provider{
+		Type:  ProviderTypeAzureOpenAI,
+		URL:   "https://res.openai.azure.com/",
+		Azure: &AzureConfig{Deployments: map[string]string{"gpt-4o": "prod gpt4o"}},
+	}
+
+	u, err := provider.chatCompletionsURL("gpt-4o", "trace=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "https://res.openai.azure.com/openai/deployments/prod%20gpt4o/chat/completions?api-version=" + defaultAzureAPIVersion + "&trace=1"
+	if u.String() != want {
+		t.Errorf("Expected %s, got %s", want, u)
+	}
+
+	provider.Azure.APIVersion = "2025-01-01-preview"
+	u, _ = provider.chatCompletionsURL("o3-mini", "api-version=old")
+	if u.Path != "/openai/deployments/o3-mini/chat/completions" || u.Query().Get("api-version") != "2025-01-01-preview" {
+		t.Errorf("Expected the model as deployment and the configured version, got %s", u)
+	}
+
+	plain := &Provider{URL: "https://api.example.com/v1"}
+	if u, _ := plain.chatCompletionsURL("m", "a=1"); u.String() != "https://api.example.com/v1/chat/completions?a=1" {
+		t.Errorf("Expected the OpenAI path, got %s", u)
+	}
+}
+
+func TestAzureProvider(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	var gotURL string
+	upstream.handle = func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		if strings.Contains(r.URL.Path, "/missing/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"DeploymentNotFound","message":"The API deployment for this resource does not exist."}}`)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/filtered/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"The response was filtered","type":null,"param":"prompt","code":"content_filter","status":400,`+
+				`"innererror":{"code":"ResponsibleAIPolicyViolation","content_filter_result":{"hate":{"filtered":true,"severity":"high"}}}}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"id":"","object":"","created":0,"model":"","choices":[],"prompt_filter_results":[{"prompt_index":0}]}`+"\n\n")
+		fmt.Fprintf(w, "data: %s\n\n", contentChunk("hi"))
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+	_, router := newRouter(t, Provider{
+		Name:   "azure",
+		Type:   ProviderTypeAzureOpenAI,
+		URL:    upstream.URL,
+		Secret: "azure-key",
+		Models: []string{"gpt-4o", "missing", "filtered"},
+		Azure:  &AzureConfig{Deployments: map[string]string{"gpt-4o": "gpt4o-prod"}},
+	})
+
+	t.Run("Routing", func(t *testing.T) {
+		resp := postChat(t, context.Background(), router, chatBody("[azure]gpt-4o", false), nil)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"content":"hi"`) {
+			t.Errorf("Expected the completion, got %d: %s", resp.StatusCode, body)
+		}
+		if want := "/openai/deployments/gpt4o-prod/chat/completions?api-version=" + defaultAzureAPIVersion; gotURL != want {
+			t.Errorf("Expected %s, got %s", want, gotURL)
+		}
+		header, _ := upstream.received()
+		if header.Get("Api-Key") != "azure-key" || header.Get("Authorization") != "" {
+			t.Errorf("Expected the api-key header only, got %v", header)
+		}
+	})
+
+	errorCases := []struct {
+		model, errType, code string
+		status               int
+	}{
+		{"missing", "invalid_request_error", "DeploymentNotFound", http.StatusNotFound},
+		{"filtered", "invalid_request_error", "content_filter", http.StatusBadRequest},
+	}
+	for _, c := range errorCases {
+		t.Run("Error/"+c.model, func(t *testing.T) {
+			resp := postChat(t, context.Background(), router, chatBody("[azure]"+c.model, true), nil)
+			defer resp.Body.Close()
+			var body struct {
+				Error map[string]interface{} `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != c.status || body.Error["type"] != c.errType || body.Error["code"] != c.code {
+				t.Errorf("Expected %d %s/%s, got %d %v", c.status, c.errType, c.code, resp.StatusCode, body.Error)
+			}
+			if _, ok := body.Error["innererror"]; ok {
+				t.Error("Expected innererror to be dropped")
+			}
+		})
+	}
+}
+
+func TestAzureADToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("ad-token-1\n"), 0600)
+
+	upstream := newFakeUpstream(t, contentChunk("ok"))
+	_, router := newRouter(t, Provider{
+		Name:   "azure",
+		Type:   ProviderTypeAzureOpenAI,
+		URL:    upstream.URL,
+		Models: []string{"gpt-4o"},
+		Azure:  &AzureConfig{ADToken: "file:" + tokenFile},
+	})
+
+	resp := postChat(t, context.Background(), router, chatBody("[azure]gpt-4o", true), nil)
+	resp.Body.Close()
+	header, _ := upstream.received()
+	if header.Get("Authorization") != "Bearer ad-token-1" || header.Get("Api-Key") != "" {
+		t.Errorf("Expected the AD token as bearer, got %v", header)
+	}
+
+	// The token is cached until the refresh interval passes.
+	os.WriteFile(tokenFile, []byte("ad-token-2\n"), 0600)
+	resp = postChat(t, context.Background(), router, chatBody("[azure]gpt-4o", true), nil)
+	resp.Body.Close()
+	if header, _ := upstream.received(); header.Get("Authorization") != "Bearer ad-token-1" {
+		t.Errorf("Expected the cached token, got %v", header.Get("Authorization"))
+	}
+}
+
+func TestAzureADTokenRefresh(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	os.WriteFile(tokenFile, []byte("ad-token-1\n"), 0600)
+	provider := &Provider{Azure: &AzureConfig{ADToken: "file:" + tokenFile, TokenRefresh: time.Nanosecond}}
+
+	if token, err := provider.azureADToken(); err != nil || token != "ad-token-1" {
+		t.Fatalf("Expected the first token, got %q, %v", token, err)
+	}
+	os.Remove(tokenFile)
+	if token, err := provider.azureADToken(); err != nil || token != "ad-token-1" {
+		t.Errorf("Expected the previous token while the refresh fails, got %q, %v", token, err)
+	}
+	os.WriteFile(tokenFile, []byte("ad-token-2\n"), 0600)
+	if token, _ := provider.azureADToken(); token != "ad-token-1" {
+		t.Errorf("Expected no new attempt right after a failure, got %q", token)
+	}
+
+	missing := &Provider{Azure: &AzureConfig{ADToken: "file:" + filepath.Join(dir, "missing")}}
+	if _, err := missing.azureADToken(); err == nil {
+		t.Fatal("Expected an error without any token")
+	}
+	os.WriteFile(filepath.Join(dir, "missing"), []byte("late\n"), 0600)
+	if _, err := missing.azureADToken(); err == nil {
+		t.Error("Expected the failure to be kept until the retry interval passes")
+	}
+
+	// A slow token command only holds up callers of its own reference.
+	slow := &Provider{Azure: &AzureConfig{ADToken: "cmd:sleep 1; echo slow-" + dir}}
+	go slow.azureADToken()
+	for {
+		azureTokens.Lock()
+		entry := azureTokens.entries[slow.Azure.ADToken]
+		azureTokens.Unlock()
+		if entry != nil {
+			entry.mu.Lock()
+			running := entry.pending != nil
+			entry.mu.Unlock()
+			if running {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	provider.azureADToken()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected other references not to wait for the slow command, took %v", elapsed)
+	}
+}
+
+func TestAzureProviderValidation(t *testing.T) {
+	config := &Config{Port: 8080, Providers: []Provider{{
+		Name:   "azure",
+		Type:   ProviderTypeAzureOpenAI,
+		URL:    "https://res.openai.azure.com",
+		Models: []string{"gpt-4o"},
+		Azure:  &AzureConfig{ADToken: "cmd:az account get-access-token"},
+	}}}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected an AD token to replace the secret: %v", err)
+	}
+
+	config.Providers[0].Azure = &AzureConfig{Deployments: map[string]string{"gpt-4o": ""}}
+	config.Providers[0].DiscoverModels = true
+	err := config.Validate()
+	for _, want := range []string{"secret cannot be empty", "azure: deployments: gpt-4o has no deployment", "discoverModels is not supported"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q, got %v", want, err)
+		}
+	}
+
+	config.Providers[0].Type = ProviderTypeOpenAI
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "azure settings require type: azure-openai") {
+		t.Errorf("Expected azure settings on another type to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 30 * time.Second
+	defaultErrorRateWindow  = time.Minute
+	defaultMinRequests      = 10
+	defaultProbeTimeout     = 5 * time.Second
+	defaultProbePath        = "/models"
+)
+
+// CircuitBreakerConfig controls when requests to a provider are cut off.
+// A nil config on the provider enables the breaker with default settings.
+type CircuitBreakerConfig struct {
+	Disabled         bool          `yaml:"disabled"`
+	FailureThreshold int           `yaml:"failureThreshold"`
+	ErrorRate        float64       `yaml:"errorRate"`
+	MinRequests      int           `yaml:"minRequests"`
+	Window           time.Duration `yaml:"window"`
+	SlowThreshold    time.Duration `yaml:"slowThreshold"`
+	OpenDuration     time.Duration `yaml:"openDuration"`
+}
+
+// HealthCheckConfig enables background probes of a provider.
+type HealthCheckConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Path     string        `yaml:"path"`
+}
+
+func (c *CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	var settings CircuitBreakerConfig
+	if c != nil {
+		settings = *c
+	}
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = defaultFailureThreshold
+	}
+	if settings.OpenDuration <= 0 {
+		settings.OpenDuration = defaultOpenDuration
+	}
+	if settings.Window <= 0 {
+		settings.Window = defaultErrorRateWindow
+	}
+	if settings.MinRequests <= 0 {
+		settings.MinRequests = defaultMinRequests
+	}
+	return settings
+}
+
+func (c *CircuitBreakerConfig) validate() []string {
+	var problems []string
+	if c.FailureThreshold < 0 {
+		problems = append(problems, "failureThreshold cannot be negative")
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		problems = append(problems, "errorRate must be between 0 and 1")
+	}
+	if c.MinRequests < 0 {
+		problems = append(problems, "minRequests cannot be negative")
+	}
+	if c.Window < 0 || c.SlowThreshold < 0 || c.OpenDuration < 0 {
+		problems = append(problems, "durations cannot be negative")
+	}
+	return problems
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// outcome classifies a finished upstream call for the breaker.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored is a call that says nothing about provider health, such
+	// as one cancelled by the client or rate limited.
+	outcomeIgnored
+)
+
+type callRecord struct {
+	at     time.Time
+	failed bool
+}
+
+// circuitBreaker tracks the health of one provider. Closed lets every
+// request through, open rejects them until OpenDuration has passed, and
+// half-open lets a single trial request through to decide between the two.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	settings CircuitBreakerConfig
+	now      func() time.Time
+
+	state               circuitState
+	openedAt            time.Time
+	trialInFlight       bool
+	consecutiveFailures int
+	calls               []callRecord
+	lastError           string
+	lastErrorAt         time.Time
+}
+
+func newCircuitBreaker(config *CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{settings: config.withDefaults(), now: time.Now}
+}
+
+// Allow reports whether a request may be sent. Every allowed request must be
+// followed by exactly one call to Record.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.settings.Disabled {
+		return true
+	}
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.settings.OpenDuration {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.trialInFlight = true
+		return true
+	case circuitHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	}
+	return true
+}
+
+// RetryAfter returns how long until an open breaker lets a trial through.
+func (b *circuitBreaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != circuitOpen {
+		return 0
+	}
+	return b.settings.OpenDuration - b.now().Sub(b.openedAt)
+}
+
+// Record reports the result of a call allowed by Allow.
+func (b *circuitBreaker) Record(result outcome, latency time.Duration, errMsg string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasTrial := b.state == circuitHalfOpen && b.trialInFlight
+	b.trialInFlight = false
+	if result == outcomeIgnored {
+		return
+	}
+
+	if result == outcomeSuccess && b.settings.SlowThreshold > 0 && latency > b.settings.SlowThreshold {
+		result = outcomeFailure
+		errMsg = fmt.Sprintf("slow response: %s", latency.Round(time.Millisecond))
+	}
+
+	now := b.now()
+	b.calls = append(b.calls, callRecord{at: now, failed: result == outcomeFailure})
+	b.pruneCalls(now)
+
+	if result == outcomeSuccess {
+		b.consecutiveFailures = 0
+		if wasTrial {
+			b.close()
+		}
+		return
+	}
+
+	b.consecutiveFailures++
+	b.lastError = errMsg
+	b.lastErrorAt = now
+
+	if wasTrial || b.shouldOpen() {
+		b.open(now)
+	}
+}
+
+// ProbeResult applies the result of a background health probe. A
+// successful probe moves an open breaker to half-open so that the next
+// request can confirm recovery. A failed one counts as a failure while
+// closed, restarts the open period while open, and reopens a half-open
+// breaker that is waiting for a trial; a trial request already in flight
+// decides on its own.
+func (b *circuitBreaker) ProbeResult(ok bool, errMsg string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		if b.state == circuitOpen {
+			b.state = circuitHalfOpen
+			b.trialInFlight = false
+		}
+		return
+	}
+
+	now := b.now()
+	b.lastError = "health check: " + errMsg
+	b.lastErrorAt = now
+	if b.settings.Disabled {
+		return
+	}
+
+	switch b.state {
+	case circuitClosed:
+		b.calls = append(b.calls, callRecord{at: now, failed: true})
+		b.pruneCalls(now)
+		b.consecutiveFailures++
+		if b.shouldOpen() {
+			b.open(now)
+		}
+	case circuitOpen:
+		b.open(now)
+	case circuitHalfOpen:
+		if !b.trialInFlight {
+			b.open(now)
+		}
+	}
+}
+
+func (b *circuitBreaker) shouldOpen() bool {
+	if b.state != circuitClosed || b.settings.Disabled {
+		return false
+	}
+	if b.consecutiveFailures >= b.settings.FailureThreshold {
+		return true
+	}
+	if b.settings.ErrorRate > 0 && len(b.calls) >= b.settings.MinRequests {
+		return b.errorRate() >= b.settings.ErrorRate
+	}
+	return false
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = circuitOpen
+	b.openedAt = now
+	b.trialInFlight = false
+}
+
+func (b *circuitBreaker) close() {
+	b.state = circuitClosed
+	b.consecutiveFailures = 0
+	b.calls = nil
+}
+
+func (b *circuitBreaker) pruneCalls(now time.Time) {
+	cutoff := now.Add(-b.settings.Window)
+	i := 0
+	for i < len(b.calls) && b.calls[i].at.Before(cutoff) {
+		i++
+	}
+	b.calls = b.calls[i:]
+}
+
+func (b *circuitBreaker) errorRate() float64 {
+	if len(b.calls) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, call := range b.calls {
+		if call.failed {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(b.calls))
+}
+
+// CircuitStatus is the breaker state reported by the status endpoint.
+type CircuitStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	ErrorRate           float64    `json:"error_rate"`
+	RecentCalls         int        `json:"recent_calls"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+}
+
+func (b *circuitBreaker) Status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pruneCalls(b.now())
+	status := CircuitStatus{
+		State:               b.state.String(),
+		ConsecutiveFailures: b.consecutiveFailures,
+		ErrorRate:           b.errorRate(),
+		RecentCalls:         len(b.calls),
+		LastError:           b.lastError,
+	}
+	if b.settings.Disabled {
+		status.State = "disabled"
+	}
+	if b.state != circuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if !b.lastErrorAt.IsZero() {
+		lastErrorAt := b.lastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+	return status
+}
+
+// classifyResponse turns an upstream response status into a breaker outcome.
+// Server errors count against the provider; rate limiting and client errors
+// do not.
+func classifyResponse(statusCode int) outcome {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return outcomeIgnored
+	case statusCode >= 500:
+		return outcomeFailure
+	default:
+		return outcomeSuccess
+	}
+}
+
+// initBreakers rebuilds the breaker map from the current config, keeping the
+// state of providers whose breaker settings did not change. Callers must
+// hold s.mu.
+func (s *Server) initBreakers() {
+	breakers := make(map[string]*circuitBreaker)
+	for _, provider := range s.config.Providers {
+		settings := provider.CircuitBreaker.withDefaults()
+		if existing, ok := s.breakers[provider.Name]; ok && existing.settings == settings {
+			breakers[provider.Name] = existing
+		} else {
+			breakers[provider.Name] = newCircuitBreaker(provider.CircuitBreaker)
+		}
+	}
+	s.breakers = breakers
+}
+
+func (s *Server) breaker(providerName string) *circuitBreaker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b, ok := s.breakers[providerName]; ok {
+		return b
+	}
+	// Providers are always registered by initBreakers; this only guards
+	// against a lookup racing a reload that removed the provider.
+	return newCircuitBreaker(nil)
+}
+
+// runHealthChecks probes providers that have a healthCheck interval set.
+func (s *Server) runHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastProbe := make(map[string]time.Time)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.RLock()
+			providers := s.config.Providers
+			s.mu.RUnlock()
+
+			for i := range providers {
+				provider := &providers[i]
+				if provider.HealthCheck == nil || provider.HealthCheck.Interval <= 0 || !provider.enabled() {
+					continue
+				}
+				if now.Sub(lastProbe[provider.Name]) < provider.HealthCheck.Interval {
+					continue
+				}
+				lastProbe[provider.Name] = now
+				go s.probeProvider(ctx, provider)
+			}
+		}
+	}
+}
+
+func (s *Server) probeProvider(ctx context.Context, provider *Provider) {
+	err := probe(ctx, s.upstreamClient(provider.Name).client, provider)
+	if err != nil {
+		Log(ComponentRouting).Warn("health check failed", "provider", provider.Name, "error", err)
+		s.breaker(provider.Name).ProbeResult(false, err.Error())
+		s.statsFor(provider.Name).recordProbe(false, err.Error())
+		return
+	}
+	s.breaker(provider.Name).ProbeResult(true, "")
+	s.statsFor(provider.Name).recordProbe(true, "")
+}
+
+func probe(ctx context.Context, client *http.Client, provider *Provider) error {
+	timeout := provider.HealthCheck.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	path := provider.HealthCheck.Path
+	if path == "" {
+		path = defaultProbePath
+		if provider.Type == ProviderTypeAzureOpenAI {
+			path = azureProbePath + "?api-version=" + url.QueryEscape(provider.azureAPIVersion())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(provider.URL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if err := provider.authorize(req); err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return redactURLError(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyUpstream is an OpenAI-compatible upstream whose failures can be
+// switched on and off.
+type flakyUpstream struct {
+	*httptest.Server
+	failing atomic.Bool
+	hits    atomic.Int32
+}
+
+func newFlakyUpstream(t *testing.T) *flakyUpstream {
+	u := &flakyUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.hits.Add(1)
+		if u.failing.Load() {
+			http.Error(w, "upstream down", http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path == "/models" {
+			fmt.Fprint(w, `{"object":"list","data":[]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func sendChat(s *Server, model string) *httptest.ResponseRecorder {
+	body := `{"model":"` + model + `","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	rec := httptest.NewRecorder()
+	s.ForwardRequest(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	return rec
+}
+
+func TestCircuitBreakerRouting(t *testing.T) {
+	upstream := newFlakyUpstream(t)
+	config := &Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:   "flaky",
+			URL:    upstream.URL,
+			Secret: "sk",
+			Models: []string{"m"},
+			CircuitBreaker: &CircuitBreakerConfig{
+				FailureThreshold: 3,
+				OpenDuration:     50 * time.Millisecond,
+			},
+		}},
+	}
+	s := NewServer(config, "")
+
+	upstream.failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if rec := sendChat(s, "[flaky]m"); rec.Code != http.StatusBadGateway {
+			t.Fatalf("Expected upstream status 502, got %d", rec.Code)
+		}
+	}
+
+	t.Run("OpenSkipsUpstream", func(t *testing.T) {
+		hits := upstream.hits.Load()
+		rec := sendChat(s, "[flaky]m")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", rec.Code)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After header")
+		}
+		if upstream.hits.Load() != hits {
+			t.Error("Expected open circuit not to call the upstream")
+		}
+		if state := s.breaker("flaky").Status().State; state != "open" {
+			t.Errorf("Expected state open, got %s", state)
+		}
+	})
+
+	t.Run("HalfOpenTrialFailureReopens", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+		sendChat(s, "[flaky]m")
+		if state := s.breaker("flaky").Status().State; state != "open" {
+			t.Errorf("Expected state open after failed trial, got %s", state)
+		}
+	})
+
+	t.Run("HalfOpenTrialSuccessCloses", func(t *testing.T) {
+		upstream.failing.Store(false)
+		time.Sleep(60 * time.Millisecond)
+		if rec := sendChat(s, "[flaky]m"); rec.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rec.Code)
+		}
+		if state := s.breaker("flaky").Status().State; state != "closed" {
+			t.Errorf("Expected state closed, got %s", state)
+		}
+	})
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{
+		FailureThreshold: 100,
+		ErrorRate:        0.5,
+		MinRequests:      4,
+	})
+
+	results := []outcome{outcomeSuccess, outcomeFailure, outcomeSuccess, outcomeFailure}
+	for _, result := range results {
+		if !b.Allow() {
+			t.Fatal("Expected closed breaker to allow requests")
+		}
+		b.Record(result, 0, "boom")
+	}
+
+	if b.Allow() {
+		t.Error("Expected breaker to open at 50% error rate")
+	}
+}
+
+func TestCircuitBreakerSlowCalls(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 1, SlowThreshold: time.Second})
+
+	b.Allow()
+	b.Record(outcomeSuccess, 2*time.Second, "")
+
+	status := b.Status()
+	if status.State != "open" || !strings.Contains(status.LastError, "slow") {
+		t.Errorf("Expected slow call to open the breaker, got %+v", status)
+	}
+}
+
+func TestHealthProbe(t *testing.T) {
+	upstream := newFlakyUpstream(t)
+	config := &Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:           "flaky",
+			URL:            upstream.URL,
+			Secret:         "sk",
+			Models:         []string{"m"},
+			CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Hour},
+			HealthCheck:    &HealthCheckConfig{Interval: time.Second},
+		}},
+	}
+	s := NewServer(config, "")
+	provider := &s.config.Providers[0]
+
+	upstream.failing.Store(true)
+	s.probeProvider(context.Background(), provider)
+	if state := s.breaker("flaky").Status().State; state != "open" {
+		t.Fatalf("Expected failed probe to open the breaker, got %s", state)
+	}
+
+	upstream.failing.Store(false)
+	s.probeProvider(context.Background(), provider)
+	if state := s.breaker("flaky").Status().State; state != "half-open" {
+		t.Errorf("Expected successful probe to half-open the breaker, got %s", state)
+	}
+}
+
+func TestCircuitBreakerProbeDuringTrial(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute})
+	b.now = func() time.Time { return now }
+
+	b.Allow()
+	b.Record(outcomeFailure, 0, "boom")
+	now = now.Add(50 * time.Second)
+	b.ProbeResult(false, "down")
+	now = now.Add(20 * time.Second)
+	if b.Allow() {
+		t.Fatal("Expected a failed probe to restart the open period")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.Allow() {
+		t.Fatal("Expected a trial request once the open period passed")
+	}
+	b.ProbeResult(false, "down")
+	if status := b.Status(); status.State != "half-open" || b.Allow() {
+		t.Fatalf("Expected a failed probe to leave the running trial alone, got %+v", status)
+	}
+	b.Record(outcomeSuccess, 0, "")
+	if state := b.Status().State; state != "closed" {
+		t.Errorf("Expected the trial to close the breaker, got %s", state)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheEntries = 1000
+	defaultCacheTTL     = 24 * time.Hour
+	// maxCachedResponse bounds the size of one cached upstream stream.
+	maxCachedResponse = 4 << 20
+
+	cacheHeader = "X-Local-Router-Cache"
+)
+
+// CacheConfig enables the response cache. Only requests with temperature 0
+// are cached unless allowNonDeterministic is set.
+type CacheConfig struct {
+	Enabled               bool          `yaml:"enabled"`
+	MaxEntries            int           `yaml:"maxEntries"`
+	TTL                   time.Duration `yaml:"ttl"`
+	Dir                   string        `yaml:"dir"`
+	AllowNonDeterministic bool          `yaml:"allowNonDeterministic"`
+}
+
+func (c *CacheConfig) withDefaults() CacheConfig {
+	var settings CacheConfig
+	if c != nil {
+		settings = *c
+	}
+	if settings.MaxEntries <= 0 {
+		settings.MaxEntries = defaultCacheEntries
+	}
+	if settings.TTL <= 0 {
+		settings.TTL = defaultCacheTTL
+	}
+	return settings
+}
+
+func (c *CacheConfig) validate() []string {
+	var problems []string
+	if c.MaxEntries < 0 {
+		problems = append(problems, "maxEntries cannot be negative")
+	}
+	if c.TTL < 0 {
+		problems = append(problems, "ttl cannot be negative")
+	}
+	if c.Dir != "" {
+		if _, err := expandHome(c.Dir); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// requestHash identifies a completion request by everything that shapes the
+// answer: the provider, the upstream model, the messages, tools and sampling
+// parameters. Fields that only affect delivery are left out, so streaming and
+// non-streaming clients share entries. encoding/json sorts map keys, which
+// makes the encoding stable.
+func requestHash(providerName string, forwardRequest map[string]interface{}) string {
+	normalized := make(map[string]interface{}, len(forwardRequest)+1)
+	for k, v := range forwardRequest {
+		switch k {
+		case "stream", "stream_options", "user", "metadata":
+			continue
+		}
+		normalized[k] = v
+	}
+	normalized["provider"] = providerName
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isDeterministic reports whether a request asks for temperature 0.
+func isDeterministic(forwardRequest map[string]interface{}) bool {
+	temperature, ok := forwardRequest["temperature"].(float64)
+	return ok && temperature == 0
+}
+
+// cacheDirectives reads the request's Cache-Control header. no-cache skips
+// the lookup but stores the fresh answer; no-store skips both.
+func cacheDirectives(r *http.Request) (lookup, store bool) {
+	lookup, store = true, true
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache", "max-age=0":
+			lookup = false
+		case "no-store":
+			lookup, store = false, false
+		}
+	}
+	return lookup, store
+}
+
+type cacheEntry struct {
+	key     string
+	data    []byte
+	created time.Time
+}
+
+// diskCacheEntry is the file format of the on-disk store.
+type diskCacheEntry struct {
+	Created time.Time `json:"created"`
+	Body    string    `json:"body"`
+}
+
+// responseCache keeps raw upstream SSE streams in an in-memory LRU, backed by
+// an optional directory so entries survive restarts.
+type responseCache struct {
+	mu       sync.Mutex
+	settings CacheConfig
+	dir      string
+	entries  map[string]*list.Element
+	order    *list.List // front is most recently used
+	now      func() time.Time
+}
+
+func newResponseCache(config *CacheConfig) *responseCache {
+	settings := config.withDefaults()
+	dir, _ := expandHome(settings.Dir)
+	return &responseCache{
+		settings: settings,
+		dir:      dir,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get returns the cached stream for key, if present and not expired.
+func (c *responseCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if c.now().Sub(entry.created) < c.settings.TTL {
+			c.order.MoveToFront(elem)
+			return entry.data, true
+		}
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+
+	entry, ok := c.readDisk(key)
+	if !ok {
+		return nil, false
+	}
+	c.insert(entry)
+	return entry.data, true
+}
+
+// Put stores a complete upstream stream under key.
+func (c *responseCache) Put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, data: data, created: c.now()}
+	c.insert(entry)
+	if err := c.writeDisk(entry); err != nil {
+		Log(ComponentRouting).Warn("failed to write cache entry", "error", err)
+	}
+}
+
+func (c *responseCache) insert(entry *cacheEntry) {
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.settings.MaxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *responseCache) diskPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *responseCache) readDisk(key string) (*cacheEntry, bool) {
+	if c.dir == "" {
+		return nil, false
+	}
+	path := c.diskPath(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var stored diskCacheEntry
+	if err := json.Unmarshal(data, &stored); err != nil || c.now().Sub(stored.Created) >= c.settings.TTL {
+		os.Remove(path)
+		return nil, false
+	}
+	return &cacheEntry{key: key, data: []byte(stored.Body), created: stored.Created}, true
+}
+
+func (c *responseCache) writeDisk(entry *cacheEntry) error {
+	if c.dir == "" {
+		return nil
+	}
+	path := c.diskPath(entry.key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(diskCacheEntry{Created: entry.created, Body: string(entry.data)})
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see a partial entry.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// initCache rebuilds the response cache when its settings change. Callers
+// must hold s.mu.
+func (s *Server) initCache() {
+	if s.config.Cache == nil || !s.config.Cache.Enabled {
+		s.cache = nil
+		return
+	}
+	settings := s.config.Cache.withDefaults()
+	if s.cache != nil && s.cache.settings == settings {
+		return
+	}
+	s.cache = newResponseCache(s.config.Cache)
+}
+
+func (s *Server) responseCache() *responseCache {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cache
+}
+
+// captureBody copies what is read from an upstream body, up to limit bytes,
+// so that a complete stream can be stored once it has been relayed.
+type captureBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+	err      error
+}
+
+func newCaptureBody(body io.ReadCloser, limit int) *captureBody {
+	return &captureBody{ReadCloser: body, limit: limit}
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.overflow {
+		if b.buf.Len()+n > b.limit {
+			b.overflow = true
+			b.buf.Reset()
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Complete returns the captured stream if it ended normally: at EOF or at a
+// [DONE] event, without a read error and within the size limit.
+func (b *captureBody) Complete() ([]byte, bool) {
+	if b.overflow || (b.err != nil && !errors.Is(b.err, io.EOF)) {
+		return nil, false
+	}
+	data := b.buf.Bytes()
+	if b.err == nil && !bytes.Contains(data, []byte("[DONE]")) {
+		return nil, false
+	}
+	return data, true
+}
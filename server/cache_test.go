@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestHash(t *testing.T) {
+	base := func() map[string]interface{} {
+		return map[string]interface{}{
+			"model":       "m",
+			"messages":    []ChatMessage{{Role: "user", Content: "hi"}},
+			"temperature": 0.0,
+			"stream":      true,
+		}
+	}
+
+	h := requestHash("p", base())
+	if h == "" {
+		t.Fatal("Expected a hash")
+	}
+
+	same := base()
+	same["stream"] = false
+	same["user"] = "ci"
+	if requestHash("p", same) != h {
+		t.Error("Expected stream and user to be ignored")
+	}
+
+	for name, change := range map[string]func(map[string]interface{}){
+		"temperature": func(m map[string]interface{}) { m["temperature"] = 0.7 },
+		"messages":    func(m map[string]interface{}) { m["messages"] = []ChatMessage{{Role: "user", Content: "bye"}} },
+		"tools":       func(m map[string]interface{}) { m["tools"] = []interface{}{map[string]interface{}{"type": "function"}} },
+	} {
+		other := base()
+		change(other)
+		if requestHash("p", other) == h {
+			t.Errorf("Expected %s to change the hash", name)
+		}
+	}
+	if requestHash("q", base()) == h {
+		t.Error("Expected the provider to change the hash")
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		c := newResponseCache(&CacheConfig{MaxEntries: 2})
+		c.Put("a", []byte("A"))
+		c.Put("b", []byte("B"))
+		c.Get("a")
+		c.Put("c", []byte("C"))
+
+		if _, ok := c.Get("b"); ok {
+			t.Error("Expected b to be evicted")
+		}
+		if data, ok := c.Get("a"); !ok || string(data) != "A" {
+			t.Error("Expected a to be kept")
+		}
+	})
+
+	t.Run("ExpiresAfterTTL", func(t *testing.T) {
+		now := time.Now()
+		c := newResponseCache(&CacheConfig{TTL: time.Minute})
+		c.now = func() time.Time { return now }
+		c.Put("a", []byte("A"))
+
+		now = now.Add(2 * time.Minute)
+		if _, ok := c.Get("a"); ok {
+			t.Error("Expected the entry to expire")
+		}
+	})
+
+	t.Run("PersistsToDisk", func(t *testing.T) {
+		dir := t.TempDir()
+		key := requestHash("p", map[string]interface{}{"model": "m"})
+		newResponseCache(&CacheConfig{Dir: dir}).Put(key, []byte("data: {}\n\n"))
+
+		reopened := newResponseCache(&CacheConfig{Dir: dir})
+		if data, ok := reopened.Get(key); !ok || string(data) != "data: {}\n\n" {
+			t.Errorf("Expected the entry to be read back from disk, got %q", data)
+		}
+
+		expired := newResponseCache(&CacheConfig{Dir: dir, TTL: time.Minute})
+		expired.now = func() time.Time { return time.Now().Add(time.Hour) }
+		if _, ok := expired.Get(key); ok {
+			t.Error("Expected the disk entry to expire")
+		}
+	})
+}
+
+func TestResponseCacheRouting(t *testing.T) {
+	upstream := newFlakyUpstream(t)
+	s := NewServer(&Config{
+		Port:  8080,
+		Cache: &CacheConfig{Enabled: true, Dir: t.TempDir()},
+		Providers: []Provider{{
+			Name:   "p",
+			URL:    upstream.URL,
+			Secret: "sk",
+			Models: []string{"m"},
+		}},
+	}, "")
+
+	send := func(stream bool, temperature string, cacheControl string) *httptest.ResponseRecorder {
+		body := `{"model":"[p]m","stream":` + map[bool]string{true: "true", false: "false"}[stream] +
+			`,"temperature":` + temperature + `,"messages":[{"role":"user","content":"hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		rec := httptest.NewRecorder()
+		s.ForwardRequest(rec, req)
+		return rec
+	}
+
+	first := send(true, "0", "")
+	if got := first.Header().Get(cacheHeader); got != "miss" {
+		t.Errorf("Expected a miss, got %q", got)
+	}
+
+	second := send(true, "0", "")
+	if got := second.Header().Get(cacheHeader); got != "hit" {
+		t.Errorf("Expected a hit, got %q", got)
+	}
+	if !strings.Contains(second.Body.String(), `data: {"id":"c1"`) || !strings.Contains(second.Body.String(), `"content":"ok"`) {
+		t.Errorf("Expected the cached response replayed as SSE, got: %s", second.Body.String())
+	}
+	if upstream.hits.Load() != 1 {
+		t.Errorf("Expected 1 upstream call, got %d", upstream.hits.Load())
+	}
+
+	nonStreaming := send(false, "0", "")
+	if got := nonStreaming.Header().Get(cacheHeader); got != "hit" {
+		t.Errorf("Expected non-streaming clients to share the entry, got %q", got)
+	}
+	if !strings.Contains(nonStreaming.Body.String(), `"content":"ok"`) || strings.Contains(nonStreaming.Body.String(), "data:") {
+		t.Errorf("Expected a JSON response, got: %s", nonStreaming.Body.String())
+	}
+
+	bypass := send(true, "0", "no-cache")
+	if got := bypass.Header().Get(cacheHeader); got != "miss" {
+		t.Errorf("Expected Cache-Control: no-cache to skip the lookup, got %q", got)
+	}
+	if upstream.hits.Load() != 2 {
+		t.Errorf("Expected the bypass to reach upstream, got %d calls", upstream.hits.Load())
+	}
+
+	warm := send(true, "0.7", "")
+	if got := warm.Header().Get(cacheHeader); got != "" {
+		t.Errorf("Expected non-deterministic requests not to use the cache, got %q", got)
+	}
+
+	// A failed refresh must not replace the good entry.
+	upstream.failing.Store(true)
+	send(true, "0", "no-cache")
+	upstream.failing.Store(false)
+	if out := send(true, "0", "").Body.String(); !strings.Contains(out, `"content":"ok"`) {
+		t.Errorf("Expected upstream errors not to be cached, got: %s", out)
+	}
+}
+
+func TestResponseCacheSkipsErrorEvents(t *testing.T) {
+	cases := []struct {
+		event  string
+		cached bool
+	}{
+		{`{"error":{"message":"overloaded","type":"server_error"}}`, false},
+		// Logprobs quote output tokens verbatim, which may be "error".
+		{`{"id":"c1","choices":[{"index":0,"delta":{"content":"error"},"logprobs":{"content":[{"token":"error","logprob":0}]}}]}`, true},
+	}
+	for _, c := range cases {
+		upstream := newFakeUpstream(t, c.event)
+		s := NewServer(&Config{
+			Port:      8080,
+			Cache:     &CacheConfig{Enabled: true, Dir: t.TempDir()},
+			Providers: []Provider{{Name: "p", URL: upstream.URL, Secret: "sk", Models: []string{"m"}}},
+		}, "")
+
+		var rec *httptest.ResponseRecorder
+		for i := 0; i < 2; i++ {
+			body := `{"model":"[p]m","stream":true,"temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+			rec = httptest.NewRecorder()
+			s.ForwardRequest(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		}
+		if hit := rec.Header().Get(cacheHeader) == "hit"; hit != c.cached {
+			t.Errorf("%s: expected cached %t, got header %q", c.event, c.cached, rec.Header().Get(cacheHeader))
+		}
+	}
+}
@@ -5,56 +5,612 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Validate checks the config and reports every problem found, joined into a
+// single error.
 func (c *Config) Validate() error {
-	if c.Port <= 0 || c.Port > 65535 {
-		return errors.New("port must be between 1 and 65535")
+	var errs []error
+
+	// port is only used for the default listener.
+	if (len(c.Listen) == 0 || c.Port != 0) && (c.Port <= 0 || c.Port > 65535) {
+		errs = append(errs, errors.New("port must be between 1 and 65535"))
+	}
+
+	for i := range c.Listen {
+		for _, problem := range c.Listen[i].validate() {
+			errs = append(errs, fmt.Errorf("listen %d (%s): %s", i, c.Listen[i].Address, problem))
+		}
+	}
+
+	if c.LogFormat != "" && c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("logFormat must be text or json, got %q", c.LogFormat))
+	}
+
+	for name := range c.LogLevels {
+		if !isKnownComponent(Component(name)) {
+			errs = append(errs, fmt.Errorf("logLevels: unknown component %q", name))
+		}
+	}
+
+	for _, problem := range c.Timeouts.validate() {
+		errs = append(errs, fmt.Errorf("timeouts: %s", problem))
+	}
+
+	if c.Cache != nil {
+		for _, problem := range c.Cache.validate() {
+			errs = append(errs, fmt.Errorf("cache: %s", problem))
+		}
+	}
+
+	if c.Fixtures != nil {
+		for _, problem := range c.Fixtures.validate() {
+			errs = append(errs, fmt.Errorf("fixtures: %s", problem))
+		}
+	}
+
+	if c.Context != nil {
+		for _, problem := range c.Context.validate(c.Providers) {
+			errs = append(errs, fmt.Errorf("context: %s", problem))
+		}
+	}
+
+	if c.Admin != nil {
+		for _, problem := range c.Admin.validate(c.Providers) {
+			errs = append(errs, fmt.Errorf("admin: %s", problem))
+		}
+	}
+
+	for i := range c.Prompts {
+		for _, problem := range c.Prompts[i].validate() {
+			errs = append(errs, fmt.Errorf("prompts %d: %s", i, problem))
+		}
 	}
 
 	if len(c.Providers) == 0 {
-		return errors.New("at least one provider must be configured")
+		errs = append(errs, errors.New("at least one provider must be configured"))
 	}
 
-	for i, provider := range c.Providers {
-		if provider.Name == "" {
-			return fmt.Errorf("provider %d: name cannot be empty", i+1)
+	seen := make(map[string]int)
+	for i := range c.Providers {
+		errs = append(errs, c.Providers[i].validate(i)...)
+
+		name := c.Providers[i].Name
+		if first, ok := seen[name]; ok && name != "" {
+			errs = append(errs, fmt.Errorf("provider %s: duplicate name, already defined as provider %s", c.Providers[i].label(i), c.Providers[first].label(first)))
+		} else {
+			seen[name] = i
 		}
-		if provider.URL == "" {
-			return fmt.Errorf("provider %s: URL cannot be empty", provider.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Provider) validate(index int) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("provider %s: "+format, append([]interface{}{p.label(index)}, args...)...))
+	}
+
+	if p.Name == "" {
+		fail("name cannot be empty")
+	} else if strings.ContainsAny(p.Name, "[]") {
+		fail("name cannot contain '[' or ']'")
+	}
+
+	switch p.Type {
+	case "", ProviderTypeOpenAI, ProviderTypeMock, ProviderTypeAzureOpenAI:
+	default:
+		fail("type must be openai, azure-openai or mock, got %q", p.Type)
+	}
+	if p.Azure != nil {
+		if p.Type != ProviderTypeAzureOpenAI {
+			fail("azure settings require type: azure-openai")
 		}
-		if _, err := url.Parse(provider.URL); err != nil {
-			return fmt.Errorf("provider %s: invalid URL: %w", provider.Name, err)
+		for _, problem := range p.Azure.validate() {
+			fail("azure: %s", problem)
 		}
-		if provider.Secret == "" {
-			return fmt.Errorf("provider %s: secret cannot be empty", provider.Name)
+	}
+	if p.Type == ProviderTypeAzureOpenAI && p.DiscoverModels {
+		fail("discoverModels is not supported for azure-openai, list the deployments as models")
+	}
+	if p.Mock != nil {
+		if p.Type != ProviderTypeMock {
+			fail("mock settings require type: mock")
 		}
-		if len(provider.Models) == 0 {
-			return fmt.Errorf("provider %s: at least one model must be specified", provider.Name)
+		for _, problem := range p.Mock.validate() {
+			fail("mock: %s", problem)
 		}
-		for j, model := range provider.Models {
-			if model == "" {
-				return fmt.Errorf("provider %s: model %d cannot be empty", provider.Name, j+1)
-			}
+	}
+
+	// Mock providers never leave the process, so they need no URL or secret.
+	if p.Type != ProviderTypeMock || p.URL != "" {
+		if p.URL == "" {
+			fail("URL cannot be empty")
+		} else if u, err := url.Parse(p.URL); err != nil {
+			fail("invalid URL: %v", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			fail("URL scheme must be http or https, got %q", u.Scheme)
+		} else if u.Host == "" {
+			fail("URL must include a host")
 		}
 	}
 
-	return nil
+	if p.Secret == "" && p.Type != ProviderTypeMock && p.authType() != AuthNone && !p.usesAzureAD() {
+		fail("secret cannot be empty")
+	}
+
+	if p.ConcurrentLimit < 0 {
+		fail("concurrentLimit cannot be negative")
+	}
+
+	if len(p.Models) == 0 && !p.DiscoverModels {
+		fail("at least one model must be specified")
+	}
+	if p.DiscoverInterval < 0 {
+		fail("discoverInterval cannot be negative")
+	}
+	for model, info := range p.ModelInfo {
+		for _, problem := range info.validate() {
+			fail("modelInfo %s: %s", model, problem)
+		}
+	}
+	if p.CircuitBreaker != nil {
+		for _, problem := range p.CircuitBreaker.validate() {
+			fail("circuitBreaker: %s", problem)
+		}
+	}
+	if p.HealthCheck != nil && (p.HealthCheck.Interval < 0 || p.HealthCheck.Timeout < 0) {
+		fail("healthCheck: durations cannot be negative")
+	}
+	if p.Retry != nil {
+		for _, problem := range p.Retry.validate() {
+			fail("retry: %s", problem)
+		}
+	}
+	if p.Transform != nil {
+		for _, problem := range p.Transform.validate() {
+			fail("transform: %s", problem)
+		}
+	}
+	for model, rules := range p.ModelTransforms {
+		for _, problem := range rules.validate() {
+			fail("modelTransforms %s: %s", model, problem)
+		}
+	}
+	if p.Headers != nil {
+		for _, problem := range p.Headers.validate() {
+			fail("headers: %s", problem)
+		}
+	}
+	if p.Auth != nil {
+		for _, problem := range p.Auth.validate() {
+			fail("auth: %s", problem)
+		}
+	}
+	if p.Proxy != "" {
+		if _, err := parseProxyURL(p.Proxy); err != nil {
+			fail("%v", err)
+		}
+	}
+	if p.CAFile != "" {
+		if _, err := loadCertPool(p.CAFile); err != nil {
+			fail("caFile: %v", err)
+		}
+	}
+	if p.ConnectTimeout < 0 || p.HeaderTimeout < 0 || p.IdleStreamTimeout < 0 || p.RequestTimeout < 0 || p.FirstTokenTimeout < 0 {
+		fail("timeouts cannot be negative")
+	}
+	models := make(map[string]bool)
+	for j, model := range p.Models {
+		if model == "" {
+			fail("model %d cannot be empty", j+1)
+		} else if models[model] {
+			fail("duplicate model %q", model)
+		}
+		models[model] = true
+	}
+
+	return errs
 }
 
+func isKnownComponent(c Component) bool {
+	for _, known := range knownComponents {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// configFile is the on-disk shape of a single config file.
+type configFile struct {
+	Include []string `yaml:"include"`
+	Config  `yaml:",inline"`
+}
+
+// ConfigOverrides are command-line settings that take precedence over every
+// config file. They are reapplied on each reload.
+type ConfigOverrides struct {
+	Port     int
+	LogLevel string
+	// RecordDir and ReplayDir switch fixtures to record or replay mode.
+	RecordDir string
+	ReplayDir string
+}
+
+func (o ConfigOverrides) apply(c *Config) {
+	if o.Port != 0 {
+		// --port asks for the default loopback listener on that port.
+		c.Port = o.Port
+		c.Listen = nil
+	}
+	if o.LogLevel != "" {
+		c.LogLevel = o.LogLevel
+	}
+	for mode, dir := range map[string]string{FixturesRecord: o.RecordDir, FixturesReplay: o.ReplayDir} {
+		if dir == "" {
+			continue
+		}
+		fixtures := FixturesConfig{}
+		if c.Fixtures != nil {
+			fixtures = *c.Fixtures
+		}
+		fixtures.Mode, fixtures.Dir = mode, dir
+		c.Fixtures = &fixtures
+	}
+}
+
+// loadConfig reads filename together with everything it includes.
+//
+// Files listed under include: are loaded in order, relative to the including
+// file, and may be globs. Later includes override earlier ones and the
+// including file overrides all of its includes. Top-level settings are
+// replaced when set; providers are merged by name, with non-empty fields of
+// the overriding entry replacing the included ones and new names appended.
+// Secrets are resolved after merging.
 func loadConfig(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
+	return loadConfigWith(filename, nil)
+}
+
+// loadConfigWith loads filename like loadConfig, reading the files in pending,
+// keyed by absolute path, from memory instead of disk. It is used to check
+// edits before they are written.
+func loadConfigWith(filename string, pending map[string][]byte) (*Config, error) {
+	config, problems, err := readConfig(filename, pending)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+
+	if err := config.resolveSecrets(); err != nil {
+		return nil, err
 	}
 
+	return config, nil
+}
+
+// checkConfig loads, resolves and validates filename, collecting every
+// problem found instead of stopping at the first.
+func checkConfig(filename string) (*Config, error) {
+	config, problems, err := readConfig(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.resolveSecrets(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := config.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	return config, errors.Join(problems...)
+}
+
+// readConfig parses and merges filename and its includes. Fatal errors such
+// as unreadable or malformed files are returned as err; recoverable problems
+// such as unknown keys are collected in problems.
+func readConfig(filename string, pending map[string][]byte) (*Config, []error, error) {
 	var config Config
-	err = yaml.Unmarshal(data, &config)
+	var problems []error
+	if err := loadConfigFile(filename, &config, nil, pending, &problems); err != nil {
+		return nil, nil, err
+	}
+	return &config, problems, nil
+}
+
+func loadConfigFile(filename string, into *Config, stack []string, pending map[string][]byte, problems *[]error) error {
+	absPath, err := filepath.Abs(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+		return fmt.Errorf("failed to resolve config path %s: %w", filename, err)
 	}
+	for _, seen := range stack {
+		if seen == absPath {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(stack, absPath), " -> "))
+		}
+	}
+	stack = append(stack, absPath)
+
+	data, ok := pending[absPath]
+	if !ok {
+		data, err = os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+
+	var file configFile
+	if err := root.Decode(&file); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+	*problems = append(*problems, checkUnknownFields(&root, reflect.TypeOf(file), filename)...)
+	annotateProviderSources(&root, filename, file.Providers)
+	*problems = append(*problems, duplicateProviders(file.Providers)...)
+	into.files = append(into.files, absPath)
+
+	for _, include := range file.Include {
+		matches, err := resolveInclude(filepath.Dir(filename), include)
+		if err != nil {
+			return fmt.Errorf("%s: include %q: %w", filename, include, err)
+		}
+		for _, match := range matches {
+			if err := loadConfigFile(match, into, stack, pending, problems); err != nil {
+				return err
+			}
+		}
+	}
+
+	into.merge(&file.Config)
+	return nil
+}
+
+// duplicateProviders reports providers defined twice in the same file. Across
+// files a repeated name is an override, but within one file it is a mistake.
+func duplicateProviders(providers []Provider) []error {
+	var errs []error
+	for i := range providers {
+		for j := 0; j < i; j++ {
+			if providers[i].Name != "" && providers[i].Name == providers[j].Name {
+				errs = append(errs, fmt.Errorf("provider %s: duplicate name, already defined at %s", providers[i].label(i), providers[j].source))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func resolveInclude(baseDir, include string) ([]string, error) {
+	path, err := expandHome(include)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 && !strings.ContainsAny(path, "*?[") {
+		// Let the read report a missing file instead of silently skipping it.
+		return []string{path}, nil
+	}
+	return matches, nil
+}
+
+// annotateProviderSources records the file and line of each provider entry.
+func annotateProviderSources(root *yaml.Node, filename string, providers []Provider) {
+	seq := mappingValue(documentContent(root), "providers")
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return
+	}
+	for i, item := range seq.Content {
+		if i < len(providers) {
+			providers[i].source = fmt.Sprintf("%s:%d", filename, item.Line)
+		}
+	}
+}
+
+func documentContent(root *yaml.Node) *yaml.Node {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		return root.Content[0]
+	}
+	return root
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// merge applies the settings of other on top of c.
+func (c *Config) merge(other *Config) {
+	if other.Port != 0 {
+		c.Port = other.Port
+	}
+	if len(other.Listen) > 0 {
+		c.Listen = other.Listen
+	}
+	if other.LogLevel != "" {
+		c.LogLevel = other.LogLevel
+	}
+	if other.LogFormat != "" {
+		c.LogFormat = other.LogFormat
+	}
+	if other.LogPrompts {
+		c.LogPrompts = true
+	}
+	c.Timeouts.merge(&other.Timeouts)
+	if other.Cache != nil {
+		c.Cache = other.Cache
+	}
+	if other.Fixtures != nil {
+		c.Fixtures = other.Fixtures
+	}
+	c.Prompts = append(c.Prompts, other.Prompts...)
+	if other.Context != nil {
+		c.Context = other.Context
+	}
+	if other.Admin != nil {
+		c.Admin = other.Admin
+	}
+	for component, level := range other.LogLevels {
+		if c.LogLevels == nil {
+			c.LogLevels = make(map[string]string)
+		}
+		c.LogLevels[component] = level
+	}
+
+	for _, provider := range other.Providers {
+		if existing := c.findProviderByName(provider.Name); existing != nil {
+			existing.merge(&provider)
+		} else {
+			c.Providers = append(c.Providers, provider)
+		}
+	}
+}
+
+func (c *Config) findProviderByName(name string) *Provider {
+	if name == "" {
+		return nil
+	}
+	for i := range c.Providers {
+		if c.Providers[i].Name == name {
+			return &c.Providers[i]
+		}
+	}
+	return nil
+}
 
-	return &config, nil
+func (p *Provider) merge(other *Provider) {
+	if other.Type != "" {
+		p.Type = other.Type
+	}
+	if other.Enabled != nil {
+		p.Enabled = other.Enabled
+	}
+	if other.Mock != nil {
+		p.Mock = other.Mock
+	}
+	if other.Azure != nil {
+		p.Azure = other.Azure
+	}
+	if other.URL != "" {
+		p.URL = other.URL
+	}
+	if other.Secret != "" {
+		p.Secret = other.Secret
+	}
+	if len(other.Models) > 0 {
+		p.Models = other.Models
+	}
+	if other.ConcurrentLimit != 0 {
+		p.ConcurrentLimit = other.ConcurrentLimit
+	}
+	if other.DiscoverModels {
+		p.DiscoverModels = true
+	}
+	if len(other.DiscoverInclude) > 0 {
+		p.DiscoverInclude = other.DiscoverInclude
+	}
+	if len(other.DiscoverExclude) > 0 {
+		p.DiscoverExclude = other.DiscoverExclude
+	}
+	if other.DiscoverInterval != 0 {
+		p.DiscoverInterval = other.DiscoverInterval
+	}
+	if other.CircuitBreaker != nil {
+		p.CircuitBreaker = other.CircuitBreaker
+	}
+	if other.HealthCheck != nil {
+		p.HealthCheck = other.HealthCheck
+	}
+	if other.Retry != nil {
+		p.Retry = other.Retry
+	}
+	if other.Transform != nil {
+		p.Transform = other.Transform
+	}
+	for model, rules := range other.ModelTransforms {
+		if p.ModelTransforms == nil {
+			p.ModelTransforms = make(map[string]TransformRules)
+		}
+		p.ModelTransforms[model] = rules
+	}
+	if other.Headers != nil {
+		p.Headers = other.Headers
+	}
+	if other.Auth != nil {
+		p.Auth = other.Auth
+	}
+	if other.IncludeUsage != nil {
+		p.IncludeUsage = other.IncludeUsage
+	}
+	if other.Proxy != "" {
+		p.Proxy = other.Proxy
+	}
+	if other.CAFile != "" {
+		p.CAFile = other.CAFile
+	}
+	if other.InsecureSkipVerify {
+		p.InsecureSkipVerify = true
+	}
+	if other.ConnectTimeout != 0 {
+		p.ConnectTimeout = other.ConnectTimeout
+	}
+	if other.HeaderTimeout != 0 {
+		p.HeaderTimeout = other.HeaderTimeout
+	}
+	if other.IdleStreamTimeout != 0 {
+		p.IdleStreamTimeout = other.IdleStreamTimeout
+	}
+	if other.RequestTimeout != 0 {
+		p.RequestTimeout = other.RequestTimeout
+	}
+	if other.FirstTokenTimeout != 0 {
+		p.FirstTokenTimeout = other.FirstTokenTimeout
+	}
+	for model, info := range other.ModelInfo {
+		if p.ModelInfo == nil {
+			p.ModelInfo = make(map[string]ModelInfo)
+		}
+		p.ModelInfo[model] = info
+	}
+	switch {
+	case p.source == "":
+		p.source = other.source
+	case other.source != "":
+		p.source = other.source + ", overriding " + p.source
+	}
+}
+
+// label identifies the provider in validation errors.
+func (p *Provider) label(index int) string {
+	name := p.Name
+	if name == "" {
+		name = fmt.Sprintf("%d", index+1)
+	}
+	if p.source != "" {
+		return name + " (" + p.source + ")"
+	}
+	return name
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -16,8 +18,8 @@ func TestLoadConfig(t *testing.T) {
 			t.Errorf("Expected port 11435, got %d", config.Port)
 		}
 
-		if len(config.Providers) != 5 {
-			t.Errorf("Expected 5 providers, got %d", len(config.Providers))
+		if len(config.Providers) != 6 {
+			t.Errorf("Expected 6 providers, got %d", len(config.Providers))
 		}
 
 		// Test first provider (aliyun)
@@ -52,6 +54,14 @@ func TestLoadConfig(t *testing.T) {
 		if gitcode.Models[0] != "Qwen/Qwen3-Coder-480B-A35B-Instruct" {
 			t.Errorf("Expected model 'Qwen/Qwen3-Coder-480B-A35B-Instruct', got '%s'", gitcode.Models[0])
 		}
+
+		// The mock provider lets the example run without keys.
+		if mock := config.Providers[5]; mock.Type != ProviderTypeMock {
+			t.Errorf("Expected the last provider to be a mock, got type %q", mock.Type)
+		}
+		if err := config.Validate(); err != nil {
+			t.Errorf("Expected the example config to be valid: %v", err)
+		}
 	})
 
 	t.Run("NonExistentFile", func(t *testing.T) {
@@ -214,3 +224,181 @@ func TestConfigValidate(t *testing.T) {
 		}
 	})
 }
+
+func TestLoadConfigIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, filepath.Join(dir, "team.yaml"), `port: 9000
+logLevel: debug
+providers:
+  - name: shared
+    url: https://shared.example.com
+    secret: placeholder
+    models: [m1, m2]
+  - name: nosecret
+    url: https://nosecret.example.com
+    models: [m3]
+`)
+	writeTestConfig(t, filepath.Join(dir, "personal.yaml"), `include:
+  - team.yaml
+port: 9100
+providers:
+  - name: shared
+    secret: sk-personal
+  - name: mine
+    url: https://mine.example.com
+    secret: sk-mine
+    models: [m4]
+`)
+
+	t.Run("Merge", func(t *testing.T) {
+		config, err := loadConfig(filepath.Join(dir, "personal.yaml"))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if config.Port != 9100 {
+			t.Errorf("Expected including file to override port, got %d", config.Port)
+		}
+		if config.LogLevel != "debug" {
+			t.Errorf("Expected logLevel from include, got '%s'", config.LogLevel)
+		}
+		if len(config.Providers) != 3 {
+			t.Fatalf("Expected 3 providers, got %d", len(config.Providers))
+		}
+
+		shared := config.Providers[0]
+		if shared.Secret != "sk-personal" {
+			t.Errorf("Expected overridden secret, got '%s'", shared.Secret)
+		}
+		if shared.URL != "https://shared.example.com" || len(shared.Models) != 2 {
+			t.Errorf("Expected URL and models from include, got %s %v", shared.URL, shared.Models)
+		}
+		if config.Providers[2].Name != "mine" {
+			t.Errorf("Expected new provider to be appended, got '%s'", config.Providers[2].Name)
+		}
+		if len(config.files) != 2 {
+			t.Errorf("Expected 2 loaded files, got %d", len(config.files))
+		}
+	})
+
+	t.Run("ValidationErrorPointsAtSource", func(t *testing.T) {
+		config, err := loadConfig(filepath.Join(dir, "personal.yaml"))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		err = config.Validate()
+		if err == nil {
+			t.Fatal("Expected error for missing secret, got nil")
+		}
+		if !strings.Contains(err.Error(), "team.yaml:8") {
+			t.Errorf("Expected error to point at team.yaml:8, got: %v", err)
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		writeTestConfig(t, filepath.Join(dir, "a.yaml"), "include: [b.yaml]\n")
+		writeTestConfig(t, filepath.Join(dir, "b.yaml"), "include: [a.yaml]\n")
+
+		_, err := loadConfig(filepath.Join(dir, "a.yaml"))
+		if err == nil || !strings.Contains(err.Error(), "include cycle") {
+			t.Errorf("Expected include cycle error, got: %v", err)
+		}
+	})
+
+	t.Run("MissingInclude", func(t *testing.T) {
+		writeTestConfig(t, filepath.Join(dir, "missing.yaml"), "include: [nope.yaml]\n")
+
+		_, err := loadConfig(filepath.Join(dir, "missing.yaml"))
+		if err == nil {
+			t.Error("Expected error for missing include, got nil")
+		}
+	})
+}
+
+func TestConfigOverrides(t *testing.T) {
+	config := &Config{Port: 8080, LogLevel: "info"}
+	ConfigOverrides{Port: 9999, LogLevel: "debug"}.apply(config)
+
+	if config.Port != 9999 || config.LogLevel != "debug" {
+		t.Errorf("Expected overrides to apply, got port %d level %s", config.Port, config.LogLevel)
+	}
+}
+
+func TestConfigValidateCollectsProblems(t *testing.T) {
+	config := &Config{
+		Port: 8080,
+		Providers: []Provider{
+			{Name: "dup", URL: "ftp://example.com", Secret: "s", Models: []string{"m", "m"}},
+			{Name: "dup", URL: "https://example.com", Secret: "s", Models: []string{"m"}, ConcurrentLimit: -1},
+			{Name: "[bad]", URL: "https://example.com", Secret: "s", Models: []string{"m"}},
+		},
+	}
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors, got nil")
+	}
+
+	for _, want := range []string{
+		"URL scheme must be http or https",
+		`duplicate model "m"`,
+		"duplicate name",
+		"concurrentLimit cannot be negative",
+		"name cannot contain '[' or ']'",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q, got: %v", want, err)
+		}
+	}
+	if n := len(flattenErrors(err)); n != 5 {
+		t.Errorf("Expected 5 problems, got %d: %v", n, err)
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("UnknownFields", func(t *testing.T) {
+		path := filepath.Join(dir, "unknown.yaml")
+		writeTestConfig(t, path, `port: 8080
+prot: 8081
+providers:
+  - name: a
+    url: https://a.example.com
+    secret: sk
+    model: [m]
+`)
+
+		_, err := loadConfig(path)
+		if err == nil {
+			t.Fatal("Expected error for unknown fields, got nil")
+		}
+		if !strings.Contains(err.Error(), `unknown.yaml:2:1: unknown field "prot"`) {
+			t.Errorf("Expected line and column for prot, got: %v", err)
+		}
+		if !strings.Contains(err.Error(), `unknown.yaml:7:5: unknown field "model"`) {
+			t.Errorf("Expected line and column for model, got: %v", err)
+		}
+	})
+
+	t.Run("DuplicateProviderInFile", func(t *testing.T) {
+		path := filepath.Join(dir, "dup.yaml")
+		writeTestConfig(t, path, `port: 8080
+providers:
+  - name: a
+    url: https://a.example.com
+    secret: sk
+    models: [m]
+  - name: a
+    url: https://b.example.com
+    secret: sk
+    models: [m]
+`)
+
+		_, err := checkConfig(path)
+		if err == nil || !strings.Contains(err.Error(), "duplicate name") {
+			t.Errorf("Expected duplicate name error, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ContextReject    = "reject"
+	ContextTruncate  = "truncate"
+	ContextSummarize = "summarize"
+
+	// contextHeader reports what the context guard did to a request.
+	contextHeader = "X-Local-Router-Context"
+
+	defaultKeepRecent     = 6
+	defaultSummaryTimeout = 60 * time.Second
+
+	summaryPrompt = "Summarize the conversation below for the assistant that will continue it. " +
+		"Keep facts, decisions, open tasks, file names and identifiers; leave out pleasantries. " +
+		"Answer with the summary only."
+)
+
+// ContextConfig sets what happens to requests that overflow the context
+// window of a model with a known contextWindow. modelInfo.contextPolicy
+// overrides the policy per model.
+type ContextConfig struct {
+	// Policy is reject (the default), truncate, which drops the oldest
+	// non-system messages, or summarize, which replaces them with a summary
+	// written by SummaryModel.
+	Policy string `yaml:"policy"`
+	// SummaryModel is a model name as clients send it, such as
+	// "[aliyun]qwen-turbo".
+	SummaryModel string `yaml:"summaryModel"`
+	// KeepRecent is how many of the latest messages summarize keeps verbatim.
+	KeepRecent     int           `yaml:"keepRecent"`
+	SummaryTimeout time.Duration `yaml:"summaryTimeout"`
+}
+
+func validContextPolicy(policy string) bool {
+	switch policy {
+	case "", ContextReject, ContextTruncate, ContextSummarize:
+		return true
+	}
+	return false
+}
+
+func (c *ContextConfig) validate(providers []Provider) []string {
+	var problems []string
+	if !validContextPolicy(c.Policy) {
+		problems = append(problems, fmt.Sprintf("policy must be reject, truncate or summarize, got %q", c.Policy))
+	}
+	if c.Policy == ContextSummarize && c.SummaryModel == "" {
+		problems = append(problems, "summaryModel is required by the summarize policy")
+	}
+	if c.SummaryModel != "" && !hasProviderPrefix(providers, c.SummaryModel) {
+		problems = append(problems, fmt.Sprintf("summaryModel %q must be [provider]model with a configured provider", c.SummaryModel))
+	}
+	if c.KeepRecent < 0 {
+		problems = append(problems, "keepRecent cannot be negative")
+	}
+	if c.SummaryTimeout < 0 {
+		problems = append(problems, "summaryTimeout cannot be negative")
+	}
+	return problems
+}
+
+func hasProviderPrefix(providers []Provider, model string) bool {
+	for i := range providers {
+		if rest, ok := strings.CutPrefix(model, "["+providers[i].Name+"]"); ok && rest != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) contextSettings() ContextConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.config.Context == nil {
+		return ContextConfig{}
+	}
+	return *s.config.Context
+}
+
+// summaryRequestKey marks the internal requests that write summaries, so
+// that they are never summarized themselves.
+type summaryRequestKey struct{}
+
+// fitContext applies the context policy to a request that would overflow
+// the context window of info, and returns the value for contextHeader, or ""
+// if the request was left alone. Requests that still do not fit are
+// rejected by checkRequest.
+func (s *Server) fitContext(ctx context.Context, info *ModelInfo, request *ChatCompletionRequest) string {
+	if info == nil || info.ContextWindow <= 0 {
+		return ""
+	}
+	tokenizer := tokenizerFor(info)
+	budget := info.ContextWindow - requestedMaxTokens(request)
+	if countPromptTokens(tokenizer, request.Messages) <= budget {
+		return ""
+	}
+
+	settings := s.contextSettings()
+	policy := info.ContextPolicy
+	if policy == "" {
+		policy = settings.Policy
+	}
+	logger := Log(ComponentRouting)
+
+	switch policy {
+	case ContextSummarize:
+		if ctx.Value(summaryRequestKey{}) != nil || settings.SummaryModel == "" {
+			break
+		}
+		messages, summarized, err := s.summarizeMessages(ctx, settings, request.Messages)
+		if err != nil {
+			logger.Warn("failed to summarize conversation, truncating instead", "model", request.Model, "summary_model", settings.SummaryModel, "error", err)
+			break
+		}
+		request.Messages = messages
+		header := fmt.Sprintf("%s; summarized=%d", ContextSummarize, summarized)
+		if countPromptTokens(tokenizer, request.Messages) > budget {
+			var dropped int
+			request.Messages, dropped = truncateMessages(tokenizer, request.Messages, budget)
+			header += fmt.Sprintf("; dropped=%d", dropped)
+		}
+		logger.Info("summarized conversation to fit the context window", "model", request.Model, "summarized", summarized)
+		return header
+	case ContextTruncate:
+	default:
+		return ContextReject
+	}
+
+	messages, dropped := truncateMessages(tokenizer, request.Messages, budget)
+	request.Messages = messages
+	logger.Info("truncated conversation to fit the context window", "model", request.Model, "dropped", dropped)
+	return fmt.Sprintf("%s; dropped=%d", ContextTruncate, dropped)
+}
+
+func requestedMaxTokens(request *ChatCompletionRequest) int {
+	maxTokens := int(getFloat64(request.Extra, "max_completion_tokens"))
+	if maxTokens == 0 {
+		maxTokens = int(getFloat64(request.Extra, "max_tokens"))
+	}
+	return maxTokens
+}
+
+// truncateMessages drops the oldest non-system messages until the prompt
+// fits the budget, always keeping the last message. Tool results whose
+// call was dropped go with it. The remaining messages keep their order.
+func truncateMessages(tokenizer Tokenizer, messages []ChatMessage, budget int) ([]ChatMessage, int) {
+	var rest []int
+	for i, m := range messages {
+		if m.Role != "system" {
+			rest = append(rest, i)
+		}
+	}
+
+	drop := make(map[int]bool)
+	kept := func() []ChatMessage {
+		result := make([]ChatMessage, 0, len(messages)-len(drop))
+		for i, m := range messages {
+			if !drop[i] {
+				result = append(result, m)
+			}
+		}
+		return result
+	}
+	for len(rest) > 1 && countPromptTokens(tokenizer, kept()) > budget {
+		drop[rest[0]] = true
+		rest = rest[1:]
+		for len(rest) > 1 && messages[rest[0]].Role == "tool" {
+			drop[rest[0]] = true
+			rest = rest[1:]
+		}
+	}
+	return kept(), len(drop)
+}
+
+// summarizeMessages replaces every non-system message before the latest
+// KeepRecent with a system message holding their summary, placed where the
+// first of them was. System messages stay where they are. It returns the
+// new messages and how many were summarized.
+func (s *Server) summarizeMessages(ctx context.Context, settings ContextConfig, messages []ChatMessage) ([]ChatMessage, int, error) {
+	keep := settings.KeepRecent
+	if keep == 0 {
+		keep = defaultKeepRecent
+	}
+
+	var rest []int
+	for i, m := range messages {
+		if m.Role != "system" {
+			rest = append(rest, i)
+		}
+	}
+	split := len(rest) - keep
+	// Keep tool results together with the call that produced them.
+	for split > 0 && messages[rest[split]].Role == "tool" {
+		split--
+	}
+	if split <= 0 {
+		return nil, 0, errors.New("no older messages to summarize")
+	}
+
+	summarized := make(map[int]bool, split)
+	var transcript strings.Builder
+	for _, i := range rest[:split] {
+		m := messages[i]
+		summarized[i] = true
+		fmt.Fprintf(&transcript, "%s: %s\n", m.Role, m.Text())
+		if calls := getSlice(m.Extra, "tool_calls"); len(calls) > 0 {
+			data, _ := json.Marshal(calls)
+			fmt.Fprintf(&transcript, "%s tool calls: %s\n", m.Role, data)
+		}
+	}
+
+	timeout := settings.SummaryTimeout
+	if timeout <= 0 {
+		timeout = defaultSummaryTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, summaryRequestKey{}, true), timeout)
+	defer cancel()
+	summary, err := s.complete(ctx, settings.SummaryModel, []ChatMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]ChatMessage, 0, len(messages)-split+1)
+	for i, m := range messages {
+		if i == rest[0] {
+			result = append(result, ChatMessage{Role: "system", Content: "Summary of the earlier conversation:\n" + summary})
+		}
+		if !summarized[i] {
+			result = append(result, m)
+		}
+	}
+	return result, split, nil
+}
+
+// complete runs a non-streaming completion through the router itself, so
+// that it gets the same routing, limits and breakers as client requests.
+func (s *Server) complete(ctx context.Context, model string, messages []ChatMessage) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": model, "messages": messages, "stream": false})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := newBufferedResponse()
+	s.ForwardRequest(rec, req)
+	if rec.status != http.StatusOK {
+		return "", fmt.Errorf("%s returned %d: %s", model, rec.status, strings.TrimSpace(rec.body.String()))
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), &completion); err != nil {
+		return "", fmt.Errorf("invalid completion from %s: %w", model, err)
+	}
+	if len(completion.Choices) == 0 || strings.TrimSpace(completion.Choices[0].Message.Content) == "" {
+		return "", fmt.Errorf("empty completion from %s", model)
+	}
+	return strings.TrimSpace(completion.Choices[0].Message.Content), nil
+}
+
+// bufferedResponse collects a response in memory for internal requests.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *bufferedResponse) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// longConversation returns a system prompt and turns user/assistant pairs
+// of about 50 tokens each, ending with a short user question.
+func longConversation(turns int) string {
+	messages := []map[string]interface{}{{"role": "system", "content": "You are terse."}}
+	for i := 0; i < turns; i++ {
+		messages = append(messages,
+			map[string]interface{}{"role": "user", "content": fmt.Sprintf("question %d %s", i, strings.Repeat("word ", 20))},
+			map[string]interface{}{"role": "assistant", "content": fmt.Sprintf("answer %d %s", i, strings.Repeat("word ", 20))},
+		)
+	}
+	messages = append(messages, map[string]interface{}{"role": "user", "content": "and now?"})
+	data, _ := json.Marshal(map[string]interface{}{"model": "[up]m", "stream": false, "messages": messages})
+	return string(data)
+}
+
+func TestTruncateMessages(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "rules"},
+		{Role: "user", Content: strings.Repeat("old ", 50)},
+		{Role: "assistant", Extra: map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{"id": "call_1"}}}},
+		{Role: "tool", Content: strings.Repeat("result ", 50)},
+		{Role: "user", Content: "latest"},
+	}
+	tokenizer := approxBPETokenizer{}
+
+	got, dropped := truncateMessages(tokenizer, messages, 30)
+	if dropped != 3 || len(got) != 2 || got[0].Role != "system" || got[1].Content != "latest" {
+		t.Errorf("Expected the system prompt and the latest message, got %d dropped: %+v", dropped, got)
+	}
+
+	got, _ = truncateMessages(tokenizer, messages, 1)
+	if len(got) != 2 || got[1].Content != "latest" {
+		t.Errorf("Expected the latest message to always be kept, got %+v", got)
+	}
+
+	interleaved := []ChatMessage{
+		{Role: "user", Content: strings.Repeat("old ", 50)},
+		{Role: "system", Content: "answer in French"},
+		{Role: "user", Content: "bonjour"},
+		{Role: "user", Content: "latest"},
+	}
+	got, dropped = truncateMessages(tokenizer, interleaved, 30)
+	if dropped != 1 || len(got) != 3 || got[0].Role != "system" || got[1].Content != "bonjour" || got[2].Content != "latest" {
+		t.Errorf("Expected the oldest message to be dropped in place, got %d dropped: %+v", dropped, got)
+	}
+	got, _ = truncateMessages(tokenizer, interleaved, 1000)
+	if len(got) != 4 || got[0].Role != "user" || got[1].Role != "system" {
+		t.Errorf("Expected the order to be kept, got %+v", got)
+	}
+}
+
+func TestContextGuard(t *testing.T) {
+	newGuardedRouter := func(t *testing.T, policy string, summary *MockConfig) (*fakeUpstream, *Server, string) {
+		upstream := newFakeUpstream(t, contentChunk("ok"))
+		s, router := newRouter(t,
+			Provider{Name: "up", URL: upstream.URL, Models: []string{"m"},
+				ModelInfo: map[string]ModelInfo{"m": {ContextWindow: 300, ContextPolicy: policy}}},
+			Provider{Name: "cheap", Type: ProviderTypeMock, Models: []string{"s"}, Mock: summary},
+		)
+		s.config.Context = &ContextConfig{SummaryModel: "[cheap]s", KeepRecent: 2}
+		return upstream, s, router.URL
+	}
+	post := func(t *testing.T, url string) *http.Response {
+		resp, err := http.Post(url+"/v1/chat/completions", "application/json", strings.NewReader(longConversation(10)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	forwardedMessages := func(upstream *fakeUpstream) []map[string]interface{} {
+		_, forwarded := upstream.received()
+		var messages []map[string]interface{}
+		for _, m := range getSlice(forwarded, "messages") {
+			messages = append(messages, m.(map[string]interface{}))
+		}
+		return messages
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		_, _, url := newGuardedRouter(t, "", nil)
+		resp := post(t, url)
+		var body struct {
+			Error map[string]interface{} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusBadRequest || body.Error["code"] != "context_length_exceeded" || resp.Header.Get(contextHeader) != ContextReject {
+			t.Errorf("Expected a rejection, got %d %v, header %q", resp.StatusCode, body.Error, resp.Header.Get(contextHeader))
+		}
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		upstream, _, url := newGuardedRouter(t, ContextTruncate, nil)
+		resp := post(t, url)
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get(contextHeader), "truncate; dropped=") {
+			t.Fatalf("Expected a truncated request, got %d, header %q", resp.StatusCode, resp.Header.Get(contextHeader))
+		}
+		messages := forwardedMessages(upstream)
+		if len(messages) >= 22 || messages[0]["content"] != "You are terse." || messages[len(messages)-1]["content"] != "and now?" {
+			t.Errorf("Expected the oldest turns to be dropped, got %v", messages)
+		}
+	})
+
+	t.Run("Summarize", func(t *testing.T) {
+		upstream, _, url := newGuardedRouter(t, ContextSummarize, &MockConfig{Text: "They asked ten questions."})
+		resp := post(t, url)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get(contextHeader) != "summarize; summarized=19" {
+			t.Fatalf("Expected a summarized request, got %d, header %q", resp.StatusCode, resp.Header.Get(contextHeader))
+		}
+		messages := forwardedMessages(upstream)
+		if len(messages) != 4 || messages[1]["content"] != "Summary of the earlier conversation:\nThey asked ten questions." ||
+			messages[3]["content"] != "and now?" {
+			t.Errorf("Expected the system prompt, the summary and two recent messages, got %v", messages)
+		}
+	})
+
+	t.Run("SummaryFailureTruncates", func(t *testing.T) {
+		upstream, _, url := newGuardedRouter(t, ContextSummarize, &MockConfig{Status: http.StatusServiceUnavailable})
+		resp := post(t, url)
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get(contextHeader), "truncate; dropped=") {
+			t.Fatalf("Expected a fallback to truncation, got %d, header %q", resp.StatusCode, resp.Header.Get(contextHeader))
+		}
+		if messages := forwardedMessages(upstream); messages[len(messages)-1]["content"] != "and now?" {
+			t.Errorf("Expected the latest message to be kept, got %v", messages)
+		}
+	})
+
+	t.Run("FitsUntouched", func(t *testing.T) {
+		upstream, _, url := newGuardedRouter(t, ContextTruncate, nil)
+		resp, err := http.Post(url+"/v1/chat/completions", "application/json", strings.NewReader(chatBody("[up]m", false)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get(contextHeader) != "" || len(forwardedMessages(upstream)) != 1 {
+			t.Errorf("Expected a short request to pass unchanged, header %q", resp.Header.Get(contextHeader))
+		}
+	})
+}
+
+func TestContextConfigValidation(t *testing.T) {
+	config := &Config{
+		Port:      8080,
+		Providers: []Provider{{Name: "a", URL: "http://a", Secret: "sk", Models: []string{"m"}, ModelInfo: map[string]ModelInfo{"m": {ContextPolicy: "squash"}}}},
+		Context:   &ContextConfig{Policy: ContextSummarize, KeepRecent: -1},
+	}
+	err := config.Validate()
+	for _, want := range []string{"summaryModel is required", "keepRecent cannot be negative", `contextPolicy must be reject, truncate or summarize, got "squash"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q, got %v", want, err)
+		}
+	}
+
+	config.Context = &ContextConfig{Policy: ContextSummarize, SummaryModel: "[b]m"}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), `summaryModel "[b]m" must be [provider]model`) {
+		t.Errorf("Expected an unknown summary provider to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDiscoverInterval = time.Hour
+	discoveryTick           = 30 * time.Second
+	discoveryTimeout        = 15 * time.Second
+	// discoveryStaleIntervals is how many discover intervals the models from
+	// the last successful run are kept while later runs fail.
+	discoveryStaleIntervals = 3
+)
+
+const (
+	ModelSourceStatic     = "static"
+	ModelSourceDiscovered = "discovered"
+)
+
+// modelDiscovery holds the models fetched from providers with
+// discoverModels enabled.
+type modelDiscovery struct {
+	mu      sync.RWMutex
+	models  map[string][]string
+	lastRun map[string]time.Time
+	found   map[string]time.Time // time of the last successful run
+	trigger chan struct{}
+}
+
+func newModelDiscovery() *modelDiscovery {
+	return &modelDiscovery{
+		models:  make(map[string][]string),
+		lastRun: make(map[string]time.Time),
+		found:   make(map[string]time.Time),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// refresh asks the discovery loop to query every provider now.
+func (d *modelDiscovery) refresh() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (d *modelDiscovery) discovered(providerName string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.models[providerName]
+}
+
+func (d *modelDiscovery) set(providerName string, models []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.models[providerName] = models
+	d.lastRun[providerName] = now
+	d.found[providerName] = now
+}
+
+// failed records a failed run. The models found by the last successful run
+// are kept for discoveryStaleIntervals, after which the provider falls back
+// to its static list.
+func (d *modelDiscovery) failed(provider *Provider) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.lastRun[provider.Name] = now
+	if found, ok := d.found[provider.Name]; ok && now.Sub(found) >= discoveryStaleIntervals*provider.discoverInterval() {
+		delete(d.models, provider.Name)
+		delete(d.found, provider.Name)
+	}
+}
+
+// due reports whether the provider should be queried again.
+func (d *modelDiscovery) due(provider *Provider) bool {
+	d.mu.RLock()
+	last, ok := d.lastRun[provider.Name]
+	d.mu.RUnlock()
+	return !ok || time.Since(last) >= provider.discoverInterval()
+}
+
+func (p *Provider) discoverInterval() time.Duration {
+	if p.DiscoverInterval <= 0 {
+		return defaultDiscoverInterval
+	}
+	return p.DiscoverInterval
+}
+
+// forget drops results for providers that are no longer configured or no
+// longer have discovery enabled.
+func (d *modelDiscovery) forget(keep map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.lastRun {
+		if !keep[name] {
+			delete(d.models, name)
+			delete(d.lastRun, name)
+			delete(d.found, name)
+		}
+	}
+}
+
+// runDiscovery queries providers on start, whenever refresh is called and
+// each time a provider's discoverInterval elapses.
+func (s *Server) runDiscovery(ctx context.Context) {
+	ticker := time.NewTicker(discoveryTick)
+	defer ticker.Stop()
+
+	s.discoverAll(ctx, true)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.discovery.trigger:
+			s.discoverAll(ctx, true)
+		case <-ticker.C:
+			s.discoverAll(ctx, false)
+		}
+	}
+}
+
+func (s *Server) discoverAll(ctx context.Context, force bool) {
+	s.mu.RLock()
+	providers := s.config.Providers
+	s.mu.RUnlock()
+
+	keep := make(map[string]bool)
+	for i := range providers {
+		provider := &providers[i]
+		if !provider.DiscoverModels || !provider.enabled() {
+			continue
+		}
+		keep[provider.Name] = true
+		if !force && !s.discovery.due(provider) {
+			continue
+		}
+
+		models, err := discoverProviderModels(ctx, s.upstreamClient(provider.Name).client, provider)
+		if err != nil {
+			Log(ComponentConfig).Warn("model discovery failed", "provider", provider.Name, "error", err)
+			s.discovery.failed(provider)
+			continue
+		}
+		Log(ComponentConfig).Info("discovered models", "provider", provider.Name, "models", len(models))
+		s.discovery.set(provider.Name, models)
+	}
+	s.discovery.forget(keep)
+}
+
+// discoverProviderModels fetches the provider's /models listing and applies
+// its include and exclude filters.
+func discoverProviderModels(ctx context.Context, client *http.Client, provider *Provider) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(provider.URL, "/")+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.authorize(req); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, redactURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var listing ModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, fmt.Errorf("failed to decode models response: %w", err)
+	}
+
+	var models []string
+	for _, model := range listing.Data {
+		if model.ID == "" || !provider.discoverFilter(model.ID) {
+			continue
+		}
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+// discoverFilter reports whether a discovered model passes the provider's
+// include and exclude globs. An empty include list admits every model.
+func (p *Provider) discoverFilter(model string) bool {
+	if len(p.DiscoverInclude) > 0 {
+		included := false
+		for _, pattern := range p.DiscoverInclude {
+			if matchGlob(pattern, model) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, pattern := range p.DiscoverExclude {
+		if matchGlob(pattern, model) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob matches name against a glob where "*" matches any run of
+// characters, including "/", and "?" matches one character. Request
+// transforms and prompt policies match with it on every request, so it
+// matches directly instead of building a regexp.
+func matchGlob(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	pi, ni := 0, 0
+	// star is the index of the last "*" seen and mark the position in name
+	// it was tried at, so a mismatch can let it absorb one more character.
+	star, mark := -1, 0
+	for ni < len(n) {
+		switch {
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, ni
+			pi++
+		case pi < len(p) && (p[pi] == '?' || p[pi] == n[ni]):
+			pi++
+			ni++
+		case star >= 0:
+			mark++
+			pi, ni = star+1, mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// providerModels returns the static models of a provider followed by any
+// discovered ones that are not already listed, each with its source.
+func (s *Server) providerModels(provider *Provider) []Model {
+	if !provider.enabled() {
+		return nil
+	}
+	var models []Model
+	seen := make(map[string]bool)
+	for _, model := range provider.Models {
+		seen[model] = true
+		models = append(models, provider.describeModel(model, ModelSourceStatic))
+	}
+	if !provider.DiscoverModels {
+		return models
+	}
+	for _, model := range s.discovery.discovered(provider.Name) {
+		if seen[model] {
+			continue
+		}
+		seen[model] = true
+		models = append(models, provider.describeModel(model, ModelSourceDiscovered))
+	}
+	return models
+}
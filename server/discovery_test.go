@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestModelDiscovery(t *testing.T) {
+	var fail atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if fail.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(ModelsResponse{Object: "list", Data: []Model{
+			{ID: "static-model"},
+			{ID: "qwen-max"},
+			{ID: "qwen-embedding"},
+			{ID: "other/model"},
+		}})
+	}))
+	defer upstream.Close()
+
+	config := &Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:            "test",
+			URL:             upstream.URL + "/v1",
+			Secret:          "sk-test",
+			Models:          []string{"static-model"},
+			DiscoverModels:  true,
+			DiscoverInclude: []string{"qwen*", "static-*"},
+			DiscoverExclude: []string{"*embedding*"},
+		}},
+	}
+	s := NewServer(config, "")
+
+	t.Run("MergesWithStatic", func(t *testing.T) {
+		s.discoverAll(context.Background(), true)
+
+		models := s.providerModels(&s.config.Providers[0])
+		if len(models) != 2 {
+			t.Fatalf("Expected 2 models, got %d: %v", len(models), models)
+		}
+		if models[0].ID != "[test]static-model" || models[0].Source != ModelSourceStatic {
+			t.Errorf("Expected static model first, got %+v", models[0])
+		}
+		if models[1].ID != "[test]qwen-max" || models[1].Source != ModelSourceDiscovered {
+			t.Errorf("Expected discovered qwen-max, got %+v", models[1])
+		}
+	})
+
+	t.Run("FailureKeepsDiscovered", func(t *testing.T) {
+		fail.Store(true)
+		defer fail.Store(false)
+		s.discoverAll(context.Background(), true)
+
+		models := s.providerModels(&s.config.Providers[0])
+		if len(models) != 2 || models[1].ID != "[test]qwen-max" {
+			t.Errorf("Expected the last discovered models to be kept, got %v", models)
+		}
+	})
+
+	t.Run("StaleFallsBackToStatic", func(t *testing.T) {
+		fail.Store(true)
+		defer fail.Store(false)
+		s.discovery.mu.Lock()
+		s.discovery.found["test"] = time.Now().Add(-discoveryStaleIntervals * defaultDiscoverInterval)
+		s.discovery.mu.Unlock()
+		s.discoverAll(context.Background(), true)
+
+		models := s.providerModels(&s.config.Providers[0])
+		if len(models) != 1 || models[0].Source != ModelSourceStatic {
+			t.Errorf("Expected stale discovered models to be dropped, got %v", models)
+		}
+	})
+
+	t.Run("FailureFallsBackToStatic", func(t *testing.T) {
+		fail.Store(true)
+		defer fail.Store(false)
+		fresh := NewServer(config, "")
+		fresh.discoverAll(context.Background(), true)
+
+		models := fresh.providerModels(&fresh.config.Providers[0])
+		if len(models) != 1 || models[0].Source != ModelSourceStatic {
+			t.Errorf("Expected only the static model, got %v", models)
+		}
+	})
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"qwen*", "qwen-max", true},
+		{"*claude*", "anthropic/claude-sonnet-4.5", true},
+		{"gpt-?", "gpt-4", true},
+		{"gpt-?", "gpt-4o", false},
+		{"qwen.max", "qwen-max", false},
+		{"*", "", true},
+		{"a*b*c", "axbxbyc", true},
+		{"a*b*c", "axbxcyb", false},
+		{"*-?", "glm-4-5", true},
+		{"模型-?", "模型-x", true},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.name); got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
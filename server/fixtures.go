@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	FixturesRecord = "record"
+	FixturesReplay = "replay"
+
+	fixtureHeader = "X-Local-Router-Fixture"
+)
+
+// FixturesConfig turns on recording of upstream exchanges, or serving
+// recorded exchanges instead of calling providers. Fixtures are matched by
+// requestHash, so they are shared by streaming and non-streaming clients.
+type FixturesConfig struct {
+	Mode string `yaml:"mode"`
+	Dir  string `yaml:"dir"`
+	// Realtime replays chunks with their recorded timing instead of at once.
+	Realtime bool `yaml:"realtime"`
+}
+
+func (c *FixturesConfig) validate() []string {
+	var problems []string
+	switch c.Mode {
+	case "", FixturesRecord, FixturesReplay:
+	default:
+		problems = append(problems, fmt.Sprintf("mode must be record or replay, got %q", c.Mode))
+	}
+	if c.Mode != "" && c.Dir == "" {
+		problems = append(problems, "dir is required")
+	}
+	if _, err := expandHome(c.Dir); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+func (s *Server) fixtures() *FixturesConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.config.Fixtures == nil || s.config.Fixtures.Mode == "" {
+		return nil
+	}
+	return s.config.Fixtures
+}
+
+// fixtureChunk is one read from the upstream body, with its offset from the
+// moment the request was sent.
+type fixtureChunk struct {
+	OffsetMS int64  `json:"offset_ms"`
+	Data     string `json:"data"`
+}
+
+// fixture is the file format of one recorded exchange.
+type fixture struct {
+	Hash       string                 `json:"hash"`
+	Provider   string                 `json:"provider"`
+	RecordedAt time.Time              `json:"recorded_at"`
+	Request    map[string]interface{} `json:"request"`
+	Status     int                    `json:"status"`
+	Header     http.Header            `json:"header,omitempty"`
+	Chunks     []fixtureChunk         `json:"chunks"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func fixturePath(dir, hash string) (string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, hash+".json"), nil
+}
+
+func loadFixture(dir, hash string) (*fixture, error) {
+	path, err := fixturePath(dir, hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+func (f *fixture) save(dir string) error {
+	path, err := fixturePath(dir, f.Hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// recordedHeaders drops headers that describe the original connection
+// rather than the response.
+func recordedHeaders(header http.Header) http.Header {
+	recorded := header.Clone()
+	for _, name := range []string{"Date", "Content-Length", "Set-Cookie", "Connection", "Transfer-Encoding"} {
+		recorded.Del(name)
+	}
+	return recorded
+}
+
+// recordingBody keeps every read from an upstream body with its timing.
+type recordingBody struct {
+	io.ReadCloser
+	mu     sync.Mutex
+	start  time.Time
+	chunks []fixtureChunk
+	err    error
+}
+
+func newRecordingBody(body io.ReadCloser, start time.Time) *recordingBody {
+	return &recordingBody{ReadCloser: body, start: start}
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > 0 {
+		b.chunks = append(b.chunks, fixtureChunk{
+			OffsetMS: time.Since(b.start).Milliseconds(),
+			Data:     string(p[:n]),
+		})
+	}
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *recordingBody) fixture(hash, provider string, request map[string]interface{}, resp *http.Response) *fixture {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f := &fixture{
+		Hash:       hash,
+		Provider:   provider,
+		RecordedAt: b.start.UTC(),
+		Request:    request,
+		Status:     resp.StatusCode,
+		Header:     recordedHeaders(resp.Header),
+		Chunks:     b.chunks,
+	}
+	if b.err != nil {
+		f.Error = b.err.Error()
+	}
+	return f
+}
+
+// replayBody plays back recorded chunks, optionally with their timing.
+type replayBody struct {
+	ctx      context.Context
+	chunks   []fixtureChunk
+	realtime bool
+	start    time.Time
+	pending  []byte
+}
+
+func (f *fixture) body(ctx context.Context, realtime bool) io.ReadCloser {
+	return &replayBody{ctx: ctx, chunks: f.Chunks, realtime: realtime, start: time.Now()}
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if len(b.chunks) == 0 {
+			return 0, io.EOF
+		}
+		next := b.chunks[0]
+		b.chunks = b.chunks[1:]
+		if b.realtime {
+			wait := time.Duration(next.OffsetMS)*time.Millisecond - time.Since(b.start)
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-b.ctx.Done():
+					timer.Stop()
+					return 0, b.ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		b.pending = []byte(next.Data)
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *replayBody) Close() error {
+	return nil
+}
+
+// replayFixture answers a request from its recorded fixture.
+func (s *Server) replayFixture(w http.ResponseWriter, r *http.Request, settings *FixturesConfig, hash string, clientRequestedStream bool, modelName string, estimator *usageEstimator) {
+	f, err := loadFixture(settings.Dir, hash)
+	if err != nil {
+		Log(ComponentRouting).Warn("no fixture for request", "model", modelName, "hash", hash, "error", err)
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "fixture_not_found",
+			fmt.Sprintf("No recorded fixture %s for model %s", hash, modelName))
+		return
+	}
+
+	Log(ComponentRouting).Info("replaying fixture", "model", modelName, "hash", hash, "stream", clientRequestedStream)
+	for name, headers := range f.Header {
+		for _, h := range headers {
+			w.Header().Add(name, h)
+		}
+	}
+	w.Header().Set(fixtureHeader, hash)
+	s.HandleStreamResponse(w, f.body(r.Context(), settings.Realtime), clientRequestedStream, f.Status, modelName, estimator)
+}
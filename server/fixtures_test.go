@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	upstream := newFlakyUpstream(t)
+	newTestServer := func(mode string) *Server {
+		return NewServer(&Config{
+			Port:     8080,
+			Fixtures: &FixturesConfig{Mode: mode, Dir: dir},
+			Providers: []Provider{{
+				Name:   "p",
+				URL:    upstream.URL,
+				Secret: "sk",
+				Models: []string{"m"},
+			}},
+		}, "")
+	}
+
+	recorded := sendChat(newTestServer(FixturesRecord), "[p]m")
+	hash := recorded.Header().Get(fixtureHeader)
+	if hash == "" {
+		t.Fatal("Expected the fixture hash header")
+	}
+
+	f, err := loadFixture(dir, hash)
+	if err != nil {
+		t.Fatalf("Expected a fixture to be written: %v", err)
+	}
+	if f.Status != http.StatusOK || f.Provider != "p" || f.Request["model"] != "m" {
+		t.Errorf("Unexpected fixture: %+v", f)
+	}
+	if len(f.Chunks) == 0 || !strings.Contains(f.Chunks[0].Data, `"content":"ok"`) {
+		t.Errorf("Expected the raw stream to be recorded, got %+v", f.Chunks)
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, hash+".json"))
+	if strings.Contains(string(raw), "Bearer") {
+		t.Error("Expected the fixture not to contain the provider secret")
+	}
+
+	upstream.Close()
+	replayer := newTestServer(FixturesReplay)
+
+	replayed := sendChat(replayer, "[p]m")
+	if replayed.Body.String() != recorded.Body.String() {
+		t.Errorf("Expected the replay to match the recording\nrecorded: %s\nreplayed: %s", recorded.Body.String(), replayed.Body.String())
+	}
+	if upstream.hits.Load() != 1 {
+		t.Errorf("Expected replay not to call upstream, got %d calls", upstream.hits.Load())
+	}
+
+	body := `{"model":"[p]m","messages":[{"role":"user","content":"hi"}]}`
+	rec := httptest.NewRecorder()
+	replayer.ForwardRequest(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"content":"ok"`) {
+		t.Errorf("Expected non-streaming clients to share the fixture, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	body = `{"model":"[p]m","stream":true,"messages":[{"role":"user","content":"never recorded"}]}`
+	rec = httptest.NewRecorder()
+	replayer.ForwardRequest(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "fixture_not_found") {
+		t.Errorf("Expected a fixture_not_found error, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReplayBodyTiming(t *testing.T) {
+	f := &fixture{Chunks: []fixtureChunk{
+		{OffsetMS: 0, Data: "a"},
+		{OffsetMS: 100, Data: "b"},
+	}}
+
+	start := time.Now()
+	data, _ := io.ReadAll(f.body(context.Background(), false))
+	if string(data) != "ab" || time.Since(start) > 50*time.Millisecond {
+		t.Errorf("Expected an immediate replay, got %q after %s", data, time.Since(start))
+	}
+
+	start = time.Now()
+	data, _ = io.ReadAll(f.body(context.Background(), true))
+	if string(data) != "ab" || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("Expected a realtime replay, got %q after %s", data, time.Since(start))
+	}
+}
+
+func TestFixtureOverrides(t *testing.T) {
+	config := &Config{Port: 8080, Fixtures: &FixturesConfig{Realtime: true}}
+	ConfigOverrides{ReplayDir: "fixtures"}.apply(config)
+	if config.Fixtures.Mode != FixturesReplay || config.Fixtures.Dir != "fixtures" || !config.Fixtures.Realtime {
+		t.Errorf("Expected --replay to switch modes and keep other settings, got %+v", config.Fixtures)
+	}
+
+	invalid := (&FixturesConfig{Mode: "playback"}).validate()
+	if len(invalid) != 2 {
+		t.Errorf("Expected an invalid mode and missing dir, got %v", invalid)
+	}
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 //go:embed openapi.json
@@ -22,13 +25,8 @@ func (s *Server) ModelsHandler(w http.ResponseWriter, r *http.Request) {
 	defer s.mu.RUnlock()
 
 	var models []Model
-	for _, provider := range s.config.Providers {
-		for _, model := range provider.Models {
-			models = append(models, Model{
-				ID:     "[" + provider.Name + "]" + model,
-				Object: "model",
-			})
-		}
+	for i := range s.config.Providers {
+		models = append(models, s.providerModels(&s.config.Providers[i])...)
 	}
 
 	response := ModelsResponse{
@@ -37,16 +35,38 @@ func (s *Server) ModelsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		GetLogger().Error("Failed to encode models response: %v", err)
+		GetLogger().Error("failed to encode models response", "error", err)
+	}
+}
+
+// ModelHandler serves /v1/models/{id} for a single model.
+func (s *Server) ModelHandler(w http.ResponseWriter, r *http.Request) {
+	modelID := strings.TrimPrefix(r.URL.Path, "/v1/models/")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.config.Providers {
+		for _, model := range s.providerModels(&s.config.Providers[i]) {
+			if model.ID == modelID {
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(model); err != nil {
+					GetLogger().Error("failed to encode model response", "error", err)
+				}
+				return
+			}
+		}
 	}
+
+	writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", "The model '"+modelID+"' does not exist")
 }
 
 func (s *Server) ForwardRequest(w http.ResponseWriter, r *http.Request) {
-	GetLogger().Info("Received %s request to %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	logger := Log(ComponentRouting)
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		GetLogger().Error("Failed to read request body: %v", err)
+		logger.Error("failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -54,21 +74,21 @@ func (s *Server) ForwardRequest(w http.ResponseWriter, r *http.Request) {
 
 	var requestBodyMap map[string]interface{}
 	if err := json.Unmarshal(body, &requestBodyMap); err != nil {
-		GetLogger().Error("Failed to parse request body: %v", err)
+		logger.Error("failed to parse request body", "error", err)
 		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
 		return
 	}
 
 	var request ChatCompletionRequest
 	if err := request.FromMap(requestBodyMap); err != nil {
-		GetLogger().Error("Failed to convert request body: %v", err)
+		logger.Error("failed to convert request body", "error", err)
 		http.Error(w, "Failed to convert request body", http.StatusBadRequest)
 		return
 	}
 
 	modelName := request.Model
 	if modelName == "" {
-		GetLogger().Error("Model not specified in request")
+		logger.Warn("model not specified in request")
 		http.Error(w, "Model not specified", http.StatusBadRequest)
 		return
 	}
@@ -76,7 +96,7 @@ func (s *Server) ForwardRequest(w http.ResponseWriter, r *http.Request) {
 	provider := s.FindProvider(modelName)
 
 	if provider == nil {
-		GetLogger().Error("Provider not found for model: %s", modelName)
+		logger.Warn("provider not found", "model", modelName)
 		http.Error(w, "Provider not found for model: "+modelName, http.StatusBadRequest)
 		return
 	}
@@ -84,69 +104,242 @@ func (s *Server) ForwardRequest(w http.ResponseWriter, r *http.Request) {
 	clientRequestedStream := request.Stream
 
 	actualModelName := s.GetActualModelName(modelName)
-	release := s.acquireSlot(provider.Name)
-	defer release()
+	provider.transformRequest(actualModelName, request.Extra)
+
+	if policies := s.promptPolicies(); len(policies) > 0 {
+		messages, err := applyPromptPolicies(policies, request.Messages, newPromptVars(r, provider, actualModelName, modelName))
+		if err != nil {
+			logger.Error("failed to apply prompt policies", "model", modelName, "error", err)
+			writeOpenAIError(w, http.StatusInternalServerError, "server_error", "prompt_policy_failed", "Failed to apply prompt policies")
+			return
+		}
+		request.Messages = messages
+	}
+
+	info := provider.lookupModelInfo(actualModelName)
+	if applied := s.fitContext(r.Context(), info, &request); applied != "" {
+		w.Header().Set(contextHeader, applied)
+	}
+	if info != nil {
+		if err := info.checkRequest(modelName, &request); err != nil {
+			logger.Warn("rejected request", "model", modelName, "reason", err.message)
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.code, err.message)
+			return
+		}
+	}
+	estimator := newUsageEstimator(info, request.Messages)
 
 	// Update the request for forwarding
 	forwardRequest := request.ToMap()
 	forwardRequest["model"] = actualModelName
 	forwardRequest["stream"] = true
+	if provider.includeUsage() {
+		streamOptions := make(map[string]interface{})
+		for k, v := range getMap(forwardRequest, "stream_options") {
+			streamOptions[k] = v
+		}
+		streamOptions["include_usage"] = true
+		forwardRequest["stream_options"] = streamOptions
+	}
+
+	fixtures := s.fixtures()
+	var fixtureHash string
+	if fixtures != nil {
+		fixtureHash = requestHash(provider.Name, forwardRequest)
+		if fixtures.Mode == FixturesReplay {
+			s.replayFixture(w, r, fixtures, fixtureHash, clientRequestedStream, modelName, estimator)
+			return
+		}
+	}
+
+	cache := s.responseCache()
+	var cacheKey string
+	storeInCache := false
+	if cache != nil && (cache.settings.AllowNonDeterministic || isDeterministic(forwardRequest)) {
+		lookup, store := cacheDirectives(r)
+		cacheKey = requestHash(provider.Name, forwardRequest)
+		storeInCache = store && cacheKey != ""
+		if lookup && cacheKey != "" {
+			if data, ok := cache.Get(cacheKey); ok {
+				logger.Info("serving cached response", "model", modelName, "provider", provider.Name, "stream", clientRequestedStream)
+				w.Header().Set(cacheHeader, "hit")
+				if clientRequestedStream {
+					w.Header().Set("Content-Type", "text/event-stream")
+				}
+				s.HandleStreamResponse(w, io.NopCloser(bytes.NewReader(data)), clientRequestedStream, http.StatusOK, modelName, estimator)
+				return
+			}
+		}
+		w.Header().Set(cacheHeader, "miss")
+	}
+
+	breaker := s.breaker(provider.Name)
+	if !breaker.Allow() {
+		retryAfter := breaker.RetryAfter()
+		logger.Warn("provider circuit open, rejecting request", "provider", provider.Name, "retry_after", retryAfter)
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		}
+		writeOpenAIError(w, http.StatusServiceUnavailable, "server_error", "provider_unavailable", "Provider "+provider.Name+" is temporarily unavailable")
+		return
+	}
+	stats := s.statsFor(provider.Name)
+	recorded := false
+	record := func(result outcome, latency time.Duration, errMsg string) {
+		if recorded {
+			return
+		}
+		recorded = true
+		breaker.Record(result, latency, errMsg)
+		switch result {
+		case outcomeFailure:
+			stats.recordCall(latency, errMsg)
+		case outcomeSuccess:
+			stats.recordCall(latency, "")
+		}
+	}
+	defer record(outcomeIgnored, 0, "")
+
+	stats.queued.Add(1)
+	release := s.acquireSlot(provider.Name)
+	stats.queued.Add(-1)
+	stats.inFlight.Add(1)
+	defer stats.inFlight.Add(-1)
+	defer release()
+
+	logger.Info("routing request", "model", modelName, "provider", provider.Name, "stream", clientRequestedStream)
 
 	// Log the last user message from the conversation history
 	for i := len(request.Messages) - 1; i >= 0; i-- {
 		if request.Messages[i].Role == "user" {
-			GetLogger().Info("Last user message: %s", request.Messages[i].Content)
+			logger.Debug("last user message", "prompt", request.Messages[i].Text())
 			break
 		}
 	}
 
 	newBody, err := json.Marshal(forwardRequest)
 	if err != nil {
-		GetLogger().Error("Failed to marshal request body: %v", err)
+		logger.Error("failed to marshal request body", "error", err)
 		http.Error(w, "Failed to marshal request body", http.StatusInternalServerError)
 		return
 	}
 
-	targetURL, err := url.Parse(provider.URL)
+	targetURL, err := provider.chatCompletionsURL(actualModelName, r.URL.RawQuery)
 	if err != nil {
-		GetLogger().Error("Invalid provider URL %s: %v", provider.URL, err)
+		logger.Error("invalid provider URL", "provider", provider.Name, "url", provider.URL, "error", err)
 		http.Error(w, "Invalid provider URL", http.StatusInternalServerError)
 		return
 	}
 
-	targetURL.Path += "/chat/completions"
-	targetURL.RawQuery = r.URL.RawQuery
-
-	req, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL.String(), bytes.NewBuffer(newBody))
-	if err != nil {
-		GetLogger().Error("Failed to create request: %v", err)
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
-		return
+	timeouts := s.completionTimeouts(provider)
+	upstreamCtx, cancelUpstream := context.WithCancel(r.Context())
+	if !clientRequestedStream {
+		upstreamCtx, cancelUpstream = context.WithTimeout(r.Context(), timeouts.Request)
 	}
-
-	for name, headers := range r.Header {
-		for _, h := range headers {
-			req.Header.Add(name, h)
+	defer cancelUpstream()
+	watchdog := newStreamWatchdog(timeouts.FirstToken, timeouts.IdleStream, cancelUpstream)
+	defer watchdog.Stop()
+
+	active := &activeRequest{
+		id:       newRequestID(),
+		client:   clientName(r),
+		model:    modelName,
+		provider: provider.Name,
+		stream:   clientRequestedStream,
+		started:  time.Now(),
+		watchdog: watchdog,
+	}
+	s.active.add(active)
+	defer s.active.remove(active.id)
+	w.Header().Set(requestIDHeader, active.id)
+
+	newUpstreamRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(upstreamCtx, r.Method, targetURL.String(), bytes.NewReader(newBody))
+		if err != nil {
+			return nil, err
 		}
+		provider.forwardHeaders(req.Header, r.Header)
+		// The body is always the JSON built above, whatever the client sent
+		// and whatever the header policy forwards.
+		req.Header.Set("Content-Type", "application/json")
+		if err := provider.authorize(req); err != nil {
+			return nil, err
+		}
+		return req, nil
 	}
-	req.Header.Set("Authorization", "Bearer "+provider.Secret)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	uc := s.upstreamClient(provider.Name)
+	start := time.Now()
+	resp, err := sendWithRetry(upstreamCtx, uc.client, provider, newUpstreamRequest)
 	if err != nil {
-		GetLogger().Error("Failed to forward request to provider: %v", err)
+		if r.Context().Err() != nil {
+			logger.Info("client went away before upstream responded", "provider", provider.Name)
+			return
+		}
+		if reason := watchdog.Err(); errors.Is(reason, errCancelledByAdmin) {
+			logger.Warn("request cancelled before upstream responded", "provider", provider.Name, "request_id", active.id)
+			writeOpenAIError(w, streamErrorStatus(reason), "server_error", timeoutErrorCode(reason), reason.Error())
+			return
+		}
+		record(outcomeFailure, time.Since(start), err.Error())
+		if reason := watchdog.Err(); reason != nil || errors.Is(err, context.DeadlineExceeded) {
+			if reason != nil {
+				err = reason
+			}
+			logger.Error("upstream timed out", "provider", provider.Name, "error", err)
+			writeOpenAIError(w, http.StatusGatewayTimeout, "server_error", timeoutErrorCode(err), err.Error())
+			return
+		}
+		logger.Error("failed to forward request", "provider", provider.Name, "error", err)
 		http.Error(w, "Failed to forward request", http.StatusInternalServerError)
 		return
 	}
+	var capture *captureBody
+	if storeInCache && resp.StatusCode == http.StatusOK {
+		capture = newCaptureBody(resp.Body, maxCachedResponse)
+		resp.Body = capture
+	}
+	var recording *recordingBody
+	if fixtures != nil && fixtures.Mode == FixturesRecord {
+		recording = newRecordingBody(resp.Body, start)
+		resp.Body = recording
+		w.Header().Set(fixtureHeader, fixtureHash)
+	}
+	resp.Body = watchdog.wrap(resp.Body)
 	defer resp.Body.Close()
+	// Latency is measured to the response headers, but a stream that starts
+	// with status 200 can still fail with an error event, so a success is
+	// only recorded once the body has been read.
+	latency := time.Since(start)
+	if result := classifyResponse(resp.StatusCode); result != outcomeSuccess {
+		record(result, latency, resp.Status)
+	}
+
+	if provider.Type == ProviderTypeAzureOpenAI {
+		translateAzureError(resp)
+	}
+	copyResponseHeaders(w.Header(), resp.Header)
 
-	for name, headers := range resp.Header {
-		for _, h := range headers {
-			w.Header().Add(name, h)
+	streamErr := s.HandleStreamResponse(w, resp.Body, clientRequestedStream, resp.StatusCode, modelName, estimator)
+	if errors.Is(streamErr, errUpstreamErrorEvent) {
+		record(outcomeFailure, latency, streamErr.Error())
+	} else {
+		record(outcomeSuccess, latency, "")
+	}
+
+	if recording != nil {
+		if err := recording.fixture(fixtureHash, provider.Name, forwardRequest, resp).save(fixtures.Dir); err != nil {
+			logger.Error("failed to save fixture", "hash", fixtureHash, "error", err)
+		} else {
+			logger.Debug("recorded fixture", "model", modelName, "hash", fixtureHash)
 		}
 	}
 
-	s.HandleStreamResponse(w, resp.Body, clientRequestedStream, resp.StatusCode, modelName)
+	if capture != nil && streamErr == nil {
+		if data, ok := capture.Complete(); ok {
+			cache.Put(cacheKey, bytes.Clone(data))
+		}
+	}
 }
 
 func (s *Server) ConfigReloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -157,12 +350,16 @@ func (s *Server) ConfigReloadHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	newConfig, err := loadConfig(s.configPath)
+	newConfig, err := s.Reload("api")
 	if err != nil {
-		GetLogger().Error("Failed to reload config: %v", err)
+		if errors.Is(err, ErrInvalidConfig) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   "Config validation failed",
+				"details": err.Error(),
+			})
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error":   "Failed to reload config",
@@ -171,24 +368,10 @@ func (s *Server) ConfigReloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := newConfig.Validate(); err != nil {
-		GetLogger().Error("Config validation failed during reload: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":   "Config validation failed",
-			"details": err.Error(),
-		})
-		return
-	}
-
-	s.config = newConfig
-	s.initLimiters()
-	GetLogger().Info("Successfully reloaded configuration for %d providers", len(s.config.Providers))
-
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":   "Configuration reloaded successfully",
-		"providers": len(s.config.Providers),
+		"providers": len(newConfig.Providers),
 	})
 }
 
@@ -201,102 +384,168 @@ func (s *Server) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if _, err := w.Write(openAPISpec); err != nil {
-		GetLogger().Error("Failed to write OpenAPI spec: %v", err)
+		GetLogger().Error("failed to write OpenAPI spec", "error", err)
 		http.Error(w, "Failed to write OpenAPI spec", http.StatusInternalServerError)
 	}
 }
 
-func (s *Server) HandleStreamResponse(w http.ResponseWriter, body io.ReadCloser, isClientStreaming bool, statusCode int, modelName string) {
+// HandleStreamResponse relays an upstream SSE stream to the client, as a
+// stream or as one assembled response. It returns nil if the upstream
+// completed, and otherwise why not: an error status, an error event
+// (errUpstreamErrorEvent), a read error or an empty stream.
+func (s *Server) HandleStreamResponse(w http.ResponseWriter, body io.ReadCloser, isClientStreaming bool, statusCode int, modelName string, estimator *usageEstimator) error {
+	if isUpstreamError(statusCode, w.Header()) {
+		relayUpstreamError(w, body, statusCode, modelName)
+		return fmt.Errorf("upstream returned status %d", statusCode)
+	}
+
+	logger := Log(ComponentStreaming)
 	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxSSELine)
+	accumulator := newStreamAccumulator()
 	var fullContent strings.Builder
-	var firstResponse map[string]interface{}
 	chunkCount := 0
 	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
 
 	if isClientStreaming {
-		w.WriteHeader(statusCode)
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(statusCode)
 	}
 
+	done := false
+	var eventErr error
 	for scanner.Scan() {
 		line := scanner.Text()
-		if strings.HasPrefix(line, "data:") {
-			dataStr := strings.TrimPrefix(line, "data:")
-			if dataStr == "[DONE]" {
-				if isClientStreaming {
-					fmt.Fprint(w, "data: [DONE]\n\n")
-					flusher.Flush()
-				}
-				break
-			}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		dataStr := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if dataStr == "[DONE]" {
+			done = true
+			break
+		}
 
-			var chunk map[string]interface{}
-			if err := json.Unmarshal([]byte(dataStr), &chunk); err != nil {
-				GetLogger().Warn("Failed to parse chunk: %v", err)
-				continue
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(dataStr), &chunk); err != nil {
+			logger.Warn("failed to parse chunk", "error", err)
+			continue
+		}
+		// Providers that fail after sending status 200 report it in an
+		// event of its own, which ends the stream.
+		if errValue, ok := chunk["error"]; ok {
+			eventErr = fmt.Errorf("%w: %s", errUpstreamErrorEvent, errorEventMessage(errValue))
+			if isClientStreaming {
+				fmt.Fprintf(w, "data: %s\n\n", dataStr)
+				flush()
 			}
+			break
+		}
 
-			chunkCount++
-			if firstResponse == nil {
-				firstResponse = chunk
+		chunkCount++
+		if chunkCount == 1 {
+			if watched, ok := body.(interface{ FirstToken() }); ok {
+				watched.FirstToken()
 			}
+		}
 
-			var responseChunk ChatCompletionResponse
-			if err := responseChunk.FromMap(chunk); err != nil {
-				GetLogger().Warn("Failed to parse chunk: %v", err)
-				continue
+		var responseChunk ChatCompletionResponse
+		if err := responseChunk.FromMap(chunk); err != nil {
+			logger.Warn("failed to parse chunk", "error", err)
+			continue
+		}
+		accumulator.add(&responseChunk)
+		for _, choice := range responseChunk.Choices {
+			if choice.Delta != nil && choice.Index == 0 {
+				fullContent.WriteString(choice.Delta.Content)
 			}
+		}
 
-			if len(responseChunk.Choices) > 0 && responseChunk.Choices[0].Delta != nil {
-				delta := responseChunk.Choices[0].Delta
-				if delta.Content != "" {
-					fullContent.WriteString(delta.Content)
-				}
-
-				if isClientStreaming {
-					reconstructedChunk := responseChunk
-
-					reconstructedChunk.Model = modelName
-					if reconstructedData, err := json.Marshal(reconstructedChunk); err == nil {
-						fmt.Fprintf(w, "data: %s\n\n", string(reconstructedData))
-						flusher.Flush()
-					}
-				}
+		if isClientStreaming && (len(responseChunk.Choices) > 0 || responseChunk.Usage != nil) {
+			responseChunk.Model = modelName
+			if reconstructedData, err := json.Marshal(responseChunk); err == nil {
+				fmt.Fprintf(w, "data: %s\n\n", string(reconstructedData))
+				flush()
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		GetLogger().Error("Scanner error during stream processing: %v", err)
-	}
-
-	if firstResponse != nil {
-		var finalResponse ChatCompletionResponse
-		if err := finalResponse.FromMap(firstResponse); err != nil {
-			GetLogger().Warn("Failed to parse final response: %v", err)
-		} else if len(finalResponse.Choices) > 0 && finalResponse.Choices[0].Delta != nil {
-			// Convert delta to message for non-streaming response
-			finalResponse.Choices[0].Delta = nil
-			finalResponse.Choices[0].Message = &ChatMessage{
-				Role:    "assistant",
-				Content: fullContent.String(),
-			}
+		if errors.Is(err, context.Canceled) {
+			logger.Info("stream cancelled", "model", modelName, "chunks", chunkCount)
+			return err
+		}
+		logger.Error("scanner error during stream processing", "model", modelName, "chunks", chunkCount, "error", err)
+		if isClientStreaming {
+			writeStreamError(w, err)
+			return err
+		}
+		writeOpenAIError(w, streamErrorStatus(err), "server_error", timeoutErrorCode(err), err.Error())
+		return err
+	}
+
+	if eventErr != nil {
+		logger.Error("upstream stream failed", "model", modelName, "chunks", chunkCount, "error", eventErr)
+		if !isClientStreaming {
+			writeOpenAIError(w, http.StatusBadGateway, "server_error", "upstream_error", eventErr.Error())
 		}
+		return eventErr
+	}
 
+	if accumulator.usage == nil && estimator != nil && chunkCount > 0 {
+		accumulator.usage = estimator.usage(accumulator.completionText())
 		if isClientStreaming {
-			GetLogger().Info("Assistant response: %s", fullContent.String())
-			return
+			usageChunk := ChatCompletionResponse{
+				ID:      accumulator.id,
+				Object:  "chat.completion.chunk",
+				Created: accumulator.created,
+				Model:   modelName,
+				Choices: []ChatCompletionChoice{},
+				Usage:   accumulator.usage,
+			}
+			if data, err := json.Marshal(usageChunk); err == nil {
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
 		}
+	}
+	if accumulator.usage != nil {
+		logger.Info("completion usage", "model", modelName,
+			"prompt_tokens", usageTokens(accumulator.usage, "prompt_tokens"),
+			"completion_tokens", usageTokens(accumulator.usage, "completion_tokens"),
+			"estimated", getBool(accumulator.usage, "estimated"))
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		responseData := finalResponse.ToMap()
-		responseData["choices"] = firstResponse["choices"] // Keep original choices structure
-		if err := json.NewEncoder(w).Encode(responseData); err != nil {
-			GetLogger().Error("Failed to encode complete response: %v", err)
-		} else {
-			GetLogger().Info("Successfully sent non-streaming response with %d chunks processed", chunkCount)
+	if isClientStreaming {
+		if done {
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		}
+		flush()
+		logger.Debug("assistant response", "model", modelName, "chunks", chunkCount, "response", fullContent.String())
+		if chunkCount == 0 {
+			return errEmptyStream
 		}
+		return nil
+	}
+
+	if chunkCount == 0 {
+		logger.Error("upstream stream ended without any chunks", "model", modelName)
+		writeOpenAIError(w, http.StatusBadGateway, "server_error", "empty_response", errEmptyStream.Error())
+		return errEmptyStream
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(accumulator.response(modelName)); err != nil {
+		logger.Error("failed to encode complete response", "error", err)
+	} else {
+		logger.Info("sent non-streaming response", "model", modelName, "chunks", chunkCount)
+		logger.Debug("assistant response", "model", modelName, "response", fullContent.String())
 	}
+	return nil
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+const (
+	AuthBearer = "bearer"
+	AuthHeader = "header"
+	AuthQuery  = "query"
+	AuthNone   = "none"
+
+	defaultAuthHeader = "api-key"
+	defaultAuthQuery  = "key"
+)
+
+// HeaderPolicy controls which client headers reach a provider. Hop-by-hop
+// headers are never forwarded; sensitive headers such as Authorization and
+// Cookie are only forwarded when listed in Forward.
+type HeaderPolicy struct {
+	// Forward, when set, is the allow-list of client headers to pass on.
+	// Entries may end in "*" to match a prefix, such as "X-Trace-*".
+	Forward []string `yaml:"forward"`
+	// Strip removes further client headers.
+	Strip []string `yaml:"strip"`
+	// Set adds static headers, replacing any client value.
+	Set map[string]string `yaml:"set"`
+}
+
+// AuthConfig chooses how the provider secret is sent upstream.
+type AuthConfig struct {
+	// Type is bearer (Authorization: Bearer, the default), header (the raw
+	// secret in the header named by Name), query (the query parameter
+	// named by Name) or none.
+	Type string `yaml:"type"`
+	Name string `yaml:"name"`
+}
+
+// hopByHopHeaders apply to a single connection and are never relayed.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// sensitiveHeaders carry client credentials or describe the client request
+// body and are dropped unless a policy forwards them explicitly.
+var sensitiveHeaders = []string{
+	"Authorization", "Api-Key", "X-Api-Key", "Cookie", "Host", "Content-Length",
+	// Let the transport negotiate compression so that it also decodes the
+	// response; a forwarded Accept-Encoding would leave it encoded.
+	"Accept-Encoding",
+	clientHeader,
+}
+
+func (p *HeaderPolicy) validate() []string {
+	var problems []string
+	for name := range p.Set {
+		if isHopByHop(name) {
+			problems = append(problems, fmt.Sprintf("set: %s is a hop-by-hop header", name))
+		}
+	}
+	return problems
+}
+
+func (a *AuthConfig) validate() []string {
+	switch a.Type {
+	case "", AuthBearer, AuthHeader, AuthQuery, AuthNone:
+		return nil
+	}
+	return []string{fmt.Sprintf("type must be bearer, header, query or none, got %q", a.Type)}
+}
+
+func (p *Provider) authType() string {
+	switch {
+	case p.Auth != nil && p.Auth.Type != "":
+		return p.Auth.Type
+	case p.Type == ProviderTypeAzureOpenAI:
+		return AuthHeader
+	}
+	return AuthBearer
+}
+
+func (p *Provider) authName(fallback string) string {
+	if p.Auth == nil || p.Auth.Name == "" {
+		return fallback
+	}
+	return p.Auth.Name
+}
+
+// authorize adds the provider secret to an upstream request.
+func (p *Provider) authorize(req *http.Request) error {
+	if p.usesAzureAD() {
+		token, err := p.azureADToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	switch p.authType() {
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+p.Secret)
+	case AuthHeader:
+		req.Header.Set(p.authName(defaultAuthHeader), p.Secret)
+	case AuthQuery:
+		query := req.URL.Query()
+		query.Set(p.authName(defaultAuthQuery), p.Secret)
+		req.URL.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// redactURLError drops the query from the URL in a transport error, since
+// authorize may have put the provider secret there, and the error is logged
+// and reported by the status endpoints.
+func redactURLError(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+	base, _, found := strings.Cut(urlErr.URL, "?")
+	if !found {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: base, Err: urlErr.Err}
+}
+
+// forwardHeaders copies the client headers allowed by the provider policy
+// onto an upstream request and adds the static headers.
+func (p *Provider) forwardHeaders(dst, src http.Header) {
+	policy := p.Headers
+	if policy == nil {
+		policy = &HeaderPolicy{}
+	}
+	connection := connectionHeaders(src)
+	for name, values := range src {
+		if isHopByHop(name) || connection[name] || matchHeader(policy.Strip, name) {
+			continue
+		}
+		if policy.Forward != nil {
+			if !matchHeader(policy.Forward, name) {
+				continue
+			}
+		} else if matchHeader(sensitiveHeaders, name) {
+			continue
+		}
+		for _, v := range values {
+			dst.Add(name, v)
+		}
+	}
+	for name, value := range policy.Set {
+		dst.Set(name, value)
+	}
+}
+
+// copyResponseHeaders relays upstream response headers to the client,
+// leaving out hop-by-hop headers, cookies and the body length, which no
+// longer applies once the body is re-encoded.
+func copyResponseHeaders(dst, src http.Header) {
+	connection := connectionHeaders(src)
+	for name, values := range src {
+		if isHopByHop(name) || connection[name] || name == "Content-Length" || name == "Set-Cookie" {
+			continue
+		}
+		for _, v := range values {
+			dst.Add(name, v)
+		}
+	}
+}
+
+// connectionHeaders returns the headers named in Connection, which are
+// hop-by-hop as well.
+func connectionHeaders(h http.Header) map[string]bool {
+	names := make(map[string]bool)
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[textproto.CanonicalMIMEHeaderKey(name)] = true
+			}
+		}
+	}
+	return names
+}
+
+func isHopByHop(name string) bool {
+	return matchHeader(hopByHopHeaders, name)
+}
+
+// matchHeader reports whether name is in the list, ignoring case. Entries
+// ending in "*" match by prefix.
+func matchHeader(list []string, name string) bool {
+	for _, entry := range list {
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(entry, name) {
+			return true
+		}
+	}
+	return false
+}
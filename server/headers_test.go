@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	client := http.Header{
+		"Authorization":       {"Bearer client-key"},
+		"Cookie":              {"session=1"},
+		"Connection":          {"keep-alive, X-Hop"},
+		"X-Hop":               {"1"},
+		"Keep-Alive":          {"timeout=5"},
+		"Content-Length":      {"42"},
+		"X-Trace-Id":          {"abc"},
+		"X-Debug":             {"1"},
+		"Openai-Beta":         {"assistants=v2"},
+		clientHeader:          {"editor"},
+		"Accept-Encoding":     {"br"},
+		"Proxy-Authorization": {"Basic x"},
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		dst := http.Header{}
+		(&Provider{}).forwardHeaders(dst, client)
+		for _, name := range []string{"Authorization", "Cookie", "Connection", "X-Hop", "Keep-Alive", "Content-Length", clientHeader, "Accept-Encoding", "Proxy-Authorization"} {
+			if dst.Get(name) != "" {
+				t.Errorf("Expected %s to be stripped", name)
+			}
+		}
+		for _, name := range []string{"X-Trace-Id", "X-Debug", "Openai-Beta"} {
+			if dst.Get(name) == "" {
+				t.Errorf("Expected %s to be forwarded", name)
+			}
+		}
+	})
+
+	t.Run("AllowListStripAndSet", func(t *testing.T) {
+		provider := &Provider{Headers: &HeaderPolicy{
+			Forward: []string{"x-trace-*", "X-Debug", "Cookie", "Connection"},
+			Strip:   []string{"X-Debug"},
+			Set:     map[string]string{"HTTP-Referer": "https://example.com", "X-Title": "local-router"},
+		}}
+		dst := http.Header{}
+		provider.forwardHeaders(dst, client)
+		if dst.Get("X-Trace-Id") != "abc" || dst.Get("Cookie") != "session=1" {
+			t.Errorf("Expected allow-listed headers to be forwarded, got %v", dst)
+		}
+		if dst.Get("X-Debug") != "" || dst.Get("Openai-Beta") != "" || dst.Get("Connection") != "" {
+			t.Errorf("Expected stripped, unlisted and hop-by-hop headers to be dropped, got %v", dst)
+		}
+		if dst.Get("Http-Referer") != "https://example.com" || dst.Get("X-Title") != "local-router" {
+			t.Errorf("Expected static headers, got %v", dst)
+		}
+	})
+
+	t.Run("ResponseHeaders", func(t *testing.T) {
+		dst := http.Header{}
+		copyResponseHeaders(dst, http.Header{
+			"Content-Type":      {"text/event-stream"},
+			"Content-Length":    {"10"},
+			"Transfer-Encoding": {"chunked"},
+			"Set-Cookie":        {"a=b"},
+			"X-Request-Id":      {"req-1"},
+		})
+		if len(dst) != 2 || dst.Get("Content-Type") == "" || dst.Get("X-Request-Id") == "" {
+			t.Errorf("Expected only Content-Type and X-Request-Id, got %v", dst)
+		}
+	})
+}
+
+func TestProviderAuth(t *testing.T) {
+	cases := []struct {
+		auth   *AuthConfig
+		header string
+		value  string
+		query  string
+	}{
+		{nil, "Authorization", "Bearer sk", ""},
+		{&AuthConfig{Type: AuthHeader}, "Api-Key", "sk", ""},
+		{&AuthConfig{Type: AuthHeader, Name: "x-goog-api-key"}, "X-Goog-Api-Key", "sk", ""},
+		{&AuthConfig{Type: AuthQuery}, "", "", "a=1&key=sk"},
+		{&AuthConfig{Type: AuthNone}, "Authorization", "", ""},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(http.MethodPost, "http://up/chat/completions?a=1", nil)
+		(&Provider{Secret: "sk", Auth: c.auth}).authorize(req)
+		if c.header != "" && req.Header.Get(c.header) != c.value {
+			t.Errorf("%+v: expected %s %q, got %q", c.auth, c.header, c.value, req.Header.Get(c.header))
+		}
+		if c.query != "" && req.URL.RawQuery != c.query {
+			t.Errorf("%+v: expected query %q, got %q", c.auth, c.query, req.URL.RawQuery)
+		}
+	}
+
+	config := &Config{Port: 8080, Providers: []Provider{{Name: "a", URL: "http://a", Models: []string{"m"}, Auth: &AuthConfig{Type: AuthNone}}}}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected a provider without auth to need no secret: %v", err)
+	}
+	config.Providers[0].Auth.Type = "digest"
+	config.Providers[0].Headers = &HeaderPolicy{Set: map[string]string{"Connection": "close"}}
+	err := config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "auth: type must be") || !strings.Contains(err.Error(), "headers: set: Connection is a hop-by-hop header") {
+		t.Errorf("Expected auth and header settings to be validated, got %v", err)
+	}
+}
+
+func TestQuerySecretNotInErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := "http://" + listener.Addr().String()
+	listener.Close()
+
+	provider := Provider{
+		Name:        "up",
+		URL:         unreachable,
+		Secret:      "sk-query",
+		Models:      []string{"m"},
+		Auth:        &AuthConfig{Type: AuthQuery},
+		Retry:       &RetryConfig{MaxAttempts: 1},
+		HealthCheck: &HealthCheckConfig{},
+	}
+	s, router := newRouter(t, provider)
+
+	resp := postChat(t, context.Background(), router, chatBody("[up]m", false), nil)
+	resp.Body.Close()
+	s.probeProvider(context.Background(), &provider)
+	_, discoveryErr := discoverProviderModels(context.Background(), http.DefaultClient, &provider)
+
+	status := getStatus(t, router, "/local-router/api/status")
+	up := status.Providers[0]
+	for _, got := range []string{up.LastError, up.Health.Error, discoveryErr.Error()} {
+		if got == "" || strings.Contains(got, "sk-query") {
+			t.Errorf("Expected an error without the secret, got %q", got)
+		}
+	}
+}
+
+func TestRouterHeaderPolicy(t *testing.T) {
+	upstream := newFakeUpstream(t, contentChunk("ok"))
+	_, router := newRouter(t, Provider{
+		Name:    "up",
+		URL:     upstream.URL,
+		Secret:  "sk-azure",
+		Models:  []string{"m"},
+		Auth:    &AuthConfig{Type: AuthHeader},
+		Headers: &HeaderPolicy{Set: map[string]string{"X-Title": "local-router"}},
+	})
+
+	resp := postChat(t, context.Background(), router, chatBody("[up]m", true), http.Header{
+		"Authorization": {"Bearer client-key"},
+		"Cookie":        {"session=1"},
+	})
+	resp.Body.Close()
+
+	received, _ := upstream.received()
+	if received.Get("Api-Key") != "sk-azure" {
+		t.Errorf("Expected the secret in api-key, got %q", received.Get("Api-Key"))
+	}
+	if received.Get("Authorization") != "" || received.Get("Cookie") != "" {
+		t.Errorf("Expected client credentials to be stripped, got %v", received)
+	}
+	if received.Get("X-Title") != "local-router" {
+		t.Errorf("Expected the static header, got %v", received)
+	}
+}
+
+func TestRouterHeaderAllowList(t *testing.T) {
+	upstream := newFakeUpstream(t, contentChunk("ok"))
+	_, router := newRouter(t, Provider{
+		Name:    "up",
+		URL:     upstream.URL,
+		Models:  []string{"m"},
+		Headers: &HeaderPolicy{Forward: []string{"X-Trace-*", "OpenAI-Beta"}},
+	})
+
+	resp := postChat(t, context.Background(), router, chatBody("[up]m", true), http.Header{
+		"Content-Type": {"text/plain"},
+		"X-Trace-Id":   {"t-1"},
+		"X-Other":      {"dropped"},
+	})
+	resp.Body.Close()
+
+	received, _ := upstream.received()
+	if received.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the JSON content type whatever the policy, got %q", received.Get("Content-Type"))
+	}
+	if received.Get("X-Trace-Id") != "t-1" || received.Get("X-Other") != "" {
+		t.Errorf("Expected only allow-listed headers, got %v", received)
+	}
+}
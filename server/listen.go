@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	unixScheme          = "unix://"
+	selfSignedValidity  = 365 * 24 * time.Hour
+	defaultListenHost   = "127.0.0.1"
+	defaultUnixSockMode = 0o600
+)
+
+// ListenConfig is one address the server accepts connections on: a TCP
+// host:port or a unix:///path socket, optionally with TLS. A bare string in
+// the config is taken as the address.
+type ListenConfig struct {
+	Address string `yaml:"address"`
+	// Mode is the octal permission of a unix socket, such as "0660".
+	Mode string           `yaml:"mode"`
+	TLS  *ListenTLSConfig `yaml:"tls"`
+}
+
+// ListenTLSConfig serves a listener over TLS with the given certificate, or
+// with a self-signed certificate for localhost. A self-signed certificate is
+// written to certFile and keyFile when they are set and missing, so clients
+// can trust it across restarts; otherwise it lives in memory only.
+type ListenTLSConfig struct {
+	CertFile   string `yaml:"certFile"`
+	KeyFile    string `yaml:"keyFile"`
+	SelfSigned bool   `yaml:"selfSigned"`
+}
+
+func (l *ListenConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		l.Address = node.Value
+		return nil
+	}
+	type plain ListenConfig
+	return node.Decode((*plain)(l))
+}
+
+// listenAddresses returns the configured listeners, defaulting to the
+// loopback interface on the configured port.
+func (c *Config) listenAddresses() []ListenConfig {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []ListenConfig{{Address: net.JoinHostPort(defaultListenHost, strconv.Itoa(c.Port))}}
+}
+
+func (l *ListenConfig) unixPath() (string, bool) {
+	if !strings.HasPrefix(l.Address, unixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(l.Address, unixScheme), true
+}
+
+func (l *ListenConfig) socketMode() (os.FileMode, error) {
+	if l.Mode == "" {
+		return defaultUnixSockMode, nil
+	}
+	mode, err := strconv.ParseUint(l.Mode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("mode must be an octal permission such as 0600, got %q", l.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func (l *ListenConfig) validate() []string {
+	var problems []string
+	if path, ok := l.unixPath(); ok {
+		if path == "" {
+			problems = append(problems, "unix socket path cannot be empty")
+		}
+		if _, err := l.socketMode(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	} else {
+		_, port, err := net.SplitHostPort(l.Address)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("address must be host:port or unix:///path, got %q", l.Address))
+		} else if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			problems = append(problems, fmt.Sprintf("port must be between 1 and 65535, got %q", port))
+		}
+		if l.Mode != "" {
+			problems = append(problems, "mode only applies to unix sockets")
+		}
+	}
+
+	if t := l.TLS; t != nil {
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			problems = append(problems, "tls: certFile and keyFile must be set together")
+		}
+		if t.CertFile == "" && !t.SelfSigned {
+			problems = append(problems, "tls: set certFile and keyFile, or selfSigned")
+		}
+	}
+	return problems
+}
+
+// isLoopback reports whether the listener is only reachable from this host.
+func (l *ListenConfig) isLoopback() bool {
+	if _, ok := l.unixPath(); ok {
+		return true
+	}
+	host, _, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// open binds the listener. A stale unix socket left by an earlier run is
+// removed first; one that still accepts connections is an error.
+func (l *ListenConfig) open() (net.Listener, error) {
+	var listener net.Listener
+	if path, ok := l.unixPath(); ok {
+		mode, err := l.socketMode()
+		if err != nil {
+			return nil, err
+		}
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		listener, err = net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+	} else {
+		var err error
+		listener, err = net.Listen("tcp", l.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if l.TLS == nil {
+		return listener, nil
+	}
+	tlsConfig, err := l.TLS.load()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+func (t *ListenTLSConfig) load() (*tls.Config, error) {
+	certFile, err := expandHome(t.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := expandHome(t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var cert tls.Certificate
+	switch {
+	case t.SelfSigned && (certFile == "" || !fileExists(certFile)):
+		certPEM, keyPEM, err := selfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		if certFile != "" {
+			if err := writeCertificate(certFile, keyFile, certPEM, keyPEM); err != nil {
+				return nil, err
+			}
+		}
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(cert.Certificate[0])
+		GetLogger().Info("generated self-signed certificate for localhost", "cert_file", certFile, "sha256", hex.EncodeToString(sum[:]))
+	default:
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// selfSignedCertificate returns a PEM certificate and key valid for
+// localhost, 127.0.0.1 and ::1.
+func selfSignedCertificate() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"local-router"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+func writeCertificate(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return fmt.Errorf("failed to write TLS key: %w", err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return fmt.Errorf("failed to write TLS certificate: %w", err)
+	}
+	return nil
+}
+
+// openListeners binds every configured address, closing those already
+// opened if one fails.
+func (s *Server) openListeners() ([]net.Listener, error) {
+	s.mu.RLock()
+	addresses := s.config.listenAddresses()
+	s.mu.RUnlock()
+
+	logger := GetLogger()
+	var listeners []net.Listener
+	for i := range addresses {
+		l := &addresses[i]
+		listener, err := l.open()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", l.Address, err)
+		}
+		listeners = append(listeners, listener)
+
+		if !l.isLoopback() && l.TLS == nil {
+			logger.Warn("listening on a non-loopback address without TLS", "address", l.Address)
+		}
+		logger.Info("listening", "address", l.Address, "tls", l.TLS != nil)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestListenConfig(t *testing.T) {
+	t.Run("DefaultsToLoopback", func(t *testing.T) {
+		config := &Config{Port: 11435}
+		addresses := config.listenAddresses()
+		if len(addresses) != 1 || addresses[0].Address != "127.0.0.1:11435" {
+			t.Errorf("Expected the default listener on loopback, got %+v", addresses)
+		}
+		if !addresses[0].isLoopback() {
+			t.Error("Expected the default listener to be loopback")
+		}
+	})
+
+	t.Run("BareAddressesAndMappings", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeTestConfig(t, path, `
+listen:
+  - 127.0.0.1:11435
+  - address: unix:///tmp/local-router.sock
+    mode: "0660"
+  - address: 127.0.0.1:11436
+    tls:
+      selfSigned: true
+providers:
+  - name: p
+    url: https://example.com/v1
+    secret: sk
+    models: [m]
+`)
+		config, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("Expected config to load: %v", err)
+		}
+		if err := config.Validate(); err != nil {
+			t.Fatalf("Expected config to be valid without a port: %v", err)
+		}
+		if len(config.Listen) != 3 {
+			t.Fatalf("Expected 3 listeners, got %d", len(config.Listen))
+		}
+		if config.Listen[0].Address != "127.0.0.1:11435" {
+			t.Errorf("Expected a bare address, got %q", config.Listen[0].Address)
+		}
+		if mode, _ := config.Listen[1].socketMode(); mode != 0o660 {
+			t.Errorf("Expected socket mode 0660, got %o", mode)
+		}
+		if config.Listen[2].TLS == nil || !config.Listen[2].TLS.SelfSigned {
+			t.Errorf("Expected a self-signed TLS listener, got %+v", config.Listen[2])
+		}
+	})
+
+	t.Run("PortOverrideReplacesListeners", func(t *testing.T) {
+		config := &Config{Listen: []ListenConfig{{Address: "0.0.0.0:8080"}}}
+		ConfigOverrides{Port: 9000}.apply(config)
+		addresses := config.listenAddresses()
+		if len(addresses) != 1 || addresses[0].Address != "127.0.0.1:9000" {
+			t.Errorf("Expected --port to use the loopback listener, got %+v", addresses)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cases := map[string]ListenConfig{
+			"address must be":      {Address: "11435"},
+			"port must be":         {Address: "127.0.0.1:0"},
+			"octal permission":     {Address: "unix:///tmp/x.sock", Mode: "rw"},
+			"only applies":         {Address: "127.0.0.1:80", Mode: "0600"},
+			"set together":         {Address: "127.0.0.1:443", TLS: &ListenTLSConfig{CertFile: "cert.pem"}},
+			"or selfSigned":        {Address: "127.0.0.1:443", TLS: &ListenTLSConfig{}},
+			"path cannot be empty": {Address: "unix://"},
+		}
+		for want, l := range cases {
+			problems := strings.Join(l.validate(), "; ")
+			if !strings.Contains(problems, want) {
+				t.Errorf("Expected %q for %+v, got %q", want, l, problems)
+			}
+		}
+	})
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions are not supported on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "router.sock")
+	// A socket file left behind by a crashed run must not block startup.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l := ListenConfig{Address: "unix://" + path, Mode: "0600"}
+	listener, err := l.open()
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	if _, err := l.open(); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Expected a socket in use to be reported, got %v", err)
+	}
+
+	s := NewServer(&Config{Port: 8080, Providers: []Provider{{Name: "p", URL: "http://127.0.0.1", Secret: "sk", Models: []string{"m"}}}}, "")
+	server := s.newHTTPServer()
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://local-router/v1/models")
+	if err != nil {
+		t.Fatalf("Expected a request over the socket to succeed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestSelfSignedTLSListener(t *testing.T) {
+	dir := t.TempDir()
+	l := ListenConfig{
+		Address: "127.0.0.1:0",
+		TLS: &ListenTLSConfig{
+			CertFile:   filepath.Join(dir, "tls", "localhost.crt"),
+			KeyFile:    filepath.Join(dir, "tls", "localhost.key"),
+			SelfSigned: true,
+		},
+	}
+	listener, err := l.open()
+	if err != nil {
+		t.Fatalf("Expected a self-signed listener: %v", err)
+	}
+	defer listener.Close()
+
+	certPEM, err := os.ReadFile(l.TLS.CertFile)
+	if err != nil {
+		t.Fatalf("Expected the certificate to be written: %v", err)
+	}
+	if info, err := os.Stat(l.TLS.KeyFile); err != nil || info.Mode().Perm() != 0o600 && runtime.GOOS != "windows" {
+		t.Errorf("Expected the key to be written with mode 0600: %v", err)
+	}
+
+	s := NewServer(&Config{Port: 8080, Providers: []Provider{{Name: "p", URL: "http://127.0.0.1", Secret: "sk", Models: []string{"m"}}}}, "")
+	server := s.newHTTPServer()
+	go server.Serve(listener)
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	resp, err := client.Get("https://localhost:" + port + "/v1/models")
+	if err != nil {
+		t.Fatalf("Expected the certificate to be valid for localhost: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"[p]m"`) {
+		t.Errorf("Expected the model list, got: %s", body)
+	}
+
+	// The written certificate is reused on the next start.
+	second, err := l.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Close()
+	reread, _ := os.ReadFile(l.TLS.CertFile)
+	if string(reread) != string(certPEM) {
+		t.Error("Expected the existing certificate to be reused")
+	}
+}
@@ -1,79 +1,203 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-type LogLevel int
+// Component identifies a subsystem whose log level can be tuned independently.
+type Component string
 
 const (
-	DEBUG LogLevel = iota
-	INFO
-	WARN
-	ERROR
+	ComponentServer    Component = "server"
+	ComponentRouting   Component = "routing"
+	ComponentStreaming Component = "streaming"
+	ComponentConfig    Component = "config"
 )
 
-var levelNames = map[LogLevel]string{
-	DEBUG: "DEBUG",
-	INFO:  "INFO",
-	WARN:  "WARN",
-	ERROR: "ERROR",
+var knownComponents = []Component{ComponentServer, ComponentRouting, ComponentStreaming, ComponentConfig}
+
+const redacted = "[REDACTED]"
+
+// secretKeys are attribute keys whose values are always redacted.
+var secretKeys = map[string]bool{
+	"secret":        true,
+	"authorization": true,
+	"api_key":       true,
+	"token":         true,
+}
+
+// promptKeys are attribute keys carrying conversation text, redacted unless
+// logPrompts is enabled.
+var promptKeys = map[string]bool{
+	"prompt":   true,
+	"response": true,
+	"content":  true,
+	"messages": true,
 }
 
-type Logger struct {
-	level  LogLevel
-	logger *log.Logger
+type LogOptions struct {
+	Level      slog.Level
+	Format     string
+	Components map[Component]slog.Level
+	LogPrompts bool
+	Output     io.Writer
 }
 
-func NewLogger(level LogLevel) *Logger {
-	return &Logger{
-		level:  level,
-		logger: log.New(os.Stdout, "", log.LstdFlags),
+type logSettings struct {
+	level      slog.Level
+	components map[Component]slog.Level
+}
+
+var (
+	logMu      sync.Mutex
+	logBase    slog.Handler
+	logOutput  io.Writer
+	logCache   sync.Map // Component -> *slog.Logger
+	logCurrent atomic.Pointer[logSettings]
+)
+
+func init() {
+	InitLogger(LogOptions{Level: slog.LevelInfo})
+}
+
+// InitLogger replaces the global log output and levels. Loggers obtained
+// earlier keep working but pick up the new levels only; callers should fetch
+// loggers with Log rather than caching them across reloads.
+func InitLogger(opts LogOptions) {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		// Filtering happens per component in componentHandler.
+		Level:       slog.LevelDebug,
+		ReplaceAttr: redactAttr(opts.LogPrompts),
+	}
+
+	var base slog.Handler
+	if strings.EqualFold(opts.Format, "json") {
+		base = slog.NewJSONHandler(out, handlerOpts)
+	} else {
+		base = slog.NewTextHandler(out, handlerOpts)
+	}
+
+	components := make(map[Component]slog.Level, len(opts.Components))
+	for c, l := range opts.Components {
+		components[c] = l
 	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	logBase = base
+	logOutput = out
+	logCurrent.Store(&logSettings{level: opts.Level, components: components})
+	logCache.Range(func(key, _ interface{}) bool {
+		logCache.Delete(key)
+		return true
+	})
 }
 
-func (l *Logger) SetLevel(level LogLevel) {
-	l.level = level
+// FlushLogs syncs the log output to disk when it is a file.
+func FlushLogs() {
+	logMu.Lock()
+	out := logOutput
+	logMu.Unlock()
+	if syncer, ok := out.(interface{ Sync() error }); ok {
+		syncer.Sync()
+	}
 }
 
-func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
-	if level >= l.level {
-		prefix := fmt.Sprintf("[%s] ", levelNames[level])
-		l.logger.Printf(prefix+format, args...)
+// Log returns the logger for a component.
+func Log(c Component) *slog.Logger {
+	if l, ok := logCache.Load(c); ok {
+		return l.(*slog.Logger)
 	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	l := slog.New(&componentHandler{
+		Handler:   logBase.WithAttrs([]slog.Attr{slog.String("component", string(c))}),
+		component: c,
+	})
+	logCache.Store(c, l)
+	return l
 }
 
-func (l *Logger) Debug(format string, args ...interface{}) {
-	l.log(DEBUG, format, args...)
+// GetLogger returns the general server logger.
+func GetLogger() *slog.Logger {
+	return Log(ComponentServer)
 }
 
-func (l *Logger) Info(format string, args ...interface{}) {
-	l.log(INFO, format, args...)
+func levelFor(c Component) slog.Level {
+	settings := logCurrent.Load()
+	if l, ok := settings.components[c]; ok {
+		return l
+	}
+	return settings.level
 }
 
-func (l *Logger) Warn(format string, args ...interface{}) {
-	l.log(WARN, format, args...)
+type componentHandler struct {
+	slog.Handler
+	component Component
 }
 
-func (l *Logger) Error(format string, args ...interface{}) {
-	l.log(ERROR, format, args...)
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= levelFor(h.component)
 }
 
-func (l *Logger) Panic(format string, args ...interface{}) {
-	l.logger.Panicf(format, args...)
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &componentHandler{Handler: h.Handler.WithAttrs(attrs), component: h.component}
 }
 
-var globalLogger *Logger
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{Handler: h.Handler.WithGroup(name), component: h.component}
+}
+
+func redactAttr(logPrompts bool) func([]string, slog.Attr) slog.Attr {
+	return func(_ []string, a slog.Attr) slog.Attr {
+		key := strings.ToLower(a.Key)
+		if secretKeys[key] {
+			return slog.String(a.Key, redacted)
+		}
+		if promptKeys[key] && !logPrompts {
+			return slog.String(a.Key, fmt.Sprintf("[REDACTED %d chars]", len(a.Value.String())))
+		}
+		return a
+	}
+}
 
-func InitLogger(level LogLevel) {
-	globalLogger = NewLogger(level)
+func parseLogLevel(levelStr string) slog.Level {
+	switch strings.ToUpper(levelStr) {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "INFO":
+		return slog.LevelInfo
+	case "WARN", "WARNING":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
 }
 
-func GetLogger() *Logger {
-	if globalLogger == nil {
-		globalLogger = NewLogger(INFO)
+func logOptionsFromConfig(c *Config) LogOptions {
+	components := make(map[Component]slog.Level, len(c.LogLevels))
+	for name, level := range c.LogLevels {
+		components[Component(name)] = parseLogLevel(level)
+	}
+	return LogOptions{
+		Level:      parseLogLevel(c.LogLevel),
+		Format:     c.LogFormat,
+		Components: components,
+		LogPrompts: c.LogPrompts,
 	}
-	return globalLogger
 }
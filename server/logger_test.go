@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedaction(t *testing.T) {
+	defer InitLogger(LogOptions{Level: slog.LevelInfo})
+
+	t.Run("RedactsByDefault", func(t *testing.T) {
+		var buf bytes.Buffer
+		InitLogger(LogOptions{Level: slog.LevelDebug, Output: &buf})
+
+		Log(ComponentRouting).Info("test", "secret", "sk-123", "prompt", "hello world")
+
+		out := buf.String()
+		if strings.Contains(out, "sk-123") {
+			t.Errorf("Expected secret to be redacted, got: %s", out)
+		}
+		if strings.Contains(out, "hello world") {
+			t.Errorf("Expected prompt to be redacted, got: %s", out)
+		}
+	})
+
+	t.Run("LogPrompts", func(t *testing.T) {
+		var buf bytes.Buffer
+		InitLogger(LogOptions{Level: slog.LevelDebug, Output: &buf, LogPrompts: true})
+
+		Log(ComponentRouting).Info("test", "secret", "sk-123", "prompt", "hello world")
+
+		out := buf.String()
+		if strings.Contains(out, "sk-123") {
+			t.Errorf("Expected secret to be redacted, got: %s", out)
+		}
+		if !strings.Contains(out, "hello world") {
+			t.Errorf("Expected prompt to be logged, got: %s", out)
+		}
+	})
+}
+
+func TestLoggerComponentLevels(t *testing.T) {
+	defer InitLogger(LogOptions{Level: slog.LevelInfo})
+
+	var buf bytes.Buffer
+	InitLogger(LogOptions{
+		Level:      slog.LevelWarn,
+		Format:     "json",
+		Components: map[Component]slog.Level{ComponentRouting: slog.LevelDebug},
+		Output:     &buf,
+	})
+
+	Log(ComponentRouting).Debug("routing debug")
+	Log(ComponentStreaming).Info("streaming info")
+
+	out := buf.String()
+	if !strings.Contains(out, `"msg":"routing debug"`) {
+		t.Errorf("Expected routing debug message, got: %s", out)
+	}
+	if !strings.Contains(out, `"component":"routing"`) {
+		t.Errorf("Expected component attribute, got: %s", out)
+	}
+	if strings.Contains(out, "streaming info") {
+		t.Errorf("Expected streaming info to be filtered, got: %s", out)
+	}
+}
@@ -1,65 +1,171 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
-func findConfigFile() string {
-	configPaths := []string{
-		".local-router.yaml",
-		os.Getenv("HOME") + "/.local-router.yaml",
-		os.Getenv("USERPROFILE") + "/.local-router.yaml",
+// configSearchPaths lists the locations checked, in order, when --config is
+// not given.
+func configSearchPaths() []string {
+	var paths []string
+	add := func(path string) {
+		for _, existing := range paths {
+			if existing == path {
+				return
+			}
+		}
+		paths = append(paths, path)
+	}
+
+	add(".local-router.yaml")
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		add(filepath.Join(dir, "local-router", "config.yaml"))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		add(filepath.Join(dir, "local-router", "config.yaml"))
+	}
+	for _, home := range []string{os.Getenv("HOME"), os.Getenv("USERPROFILE")} {
+		if home == "" {
+			continue
+		}
+		add(filepath.Join(home, ".config", "local-router", "config.yaml"))
+		add(filepath.Join(home, ".local-router.yaml"))
 	}
+	return paths
+}
 
+func findConfigFile() (string, error) {
+	configPaths := configSearchPaths()
 	for _, path := range configPaths {
 		if _, err := os.Stat(path); err == nil {
-			return path
+			return path, nil
 		}
 	}
 
-	panic("Failed to find example.config.yaml in HOME, USERPROFILE, or current directory")
+	return "", fmt.Errorf("no config file found; pass --config or create one of: %s", strings.Join(configPaths, ", "))
 }
 
-func parseLogLevel(levelStr string) LogLevel {
-	switch strings.ToUpper(levelStr) {
-	case "DEBUG":
-		return DEBUG
-	case "INFO":
-		return INFO
-	case "WARN", "WARNING":
-		return WARN
-	case "ERROR":
-		return ERROR
-	default:
-		return INFO
+// errCheckFailed is returned by the check command after it has printed the
+// problems it found.
+var errCheckFailed = errors.New("config check failed")
+
+// runCheck implements "local-router check": it validates the config and
+// prints every problem found, for use in CI.
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("local-router check", flag.ContinueOnError)
+	configFlag := flags.String("config", "", "path to the config file")
+	if err := flags.Parse(args); err != nil {
+		return err
 	}
+
+	configPath := *configFlag
+	if configPath == "" {
+		path, err := findConfigFile()
+		if err != nil {
+			return err
+		}
+		configPath = path
+	}
+
+	config, err := checkConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid configuration\n", configPath)
+		for _, problem := range flattenErrors(err) {
+			fmt.Fprintf(os.Stderr, "  - %v\n", problem)
+		}
+		return errCheckFailed
+	}
+
+	fmt.Printf("%s: OK (%d files, %d providers)\n", configPath, len(config.files), len(config.Providers))
+	return nil
 }
 
-func main() {
-	configPath := findConfigFile()
+// flattenErrors expands errors created with errors.Join into their parts.
+func flattenErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, flattenErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
+
+func run(args []string) error {
+	if len(args) > 0 && args[0] == "check" {
+		return runCheck(args[1:])
+	}
+
+	flags := flag.NewFlagSet("local-router", flag.ContinueOnError)
+	configFlag := flags.String("config", "", "path to the config file")
+	portFlag := flags.Int("port", 0, "listen port, overrides the config file")
+	logLevelFlag := flags.String("log-level", "", "log level (debug, info, warn, error), overrides the config file")
+	recordFlag := flags.String("record", "", "record upstream exchanges as fixtures in this directory")
+	replayFlag := flags.String("replay", "", "serve recorded fixtures from this directory instead of calling providers")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *recordFlag != "" && *replayFlag != "" {
+		return errors.New("--record and --replay cannot be used together")
+	}
+
+	configPath := *configFlag
+	if configPath == "" {
+		path, err := findConfigFile()
+		if err != nil {
+			return err
+		}
+		configPath = path
+	}
+
 	config, err := loadConfig(configPath)
 	if err != nil {
-		log.Panicf("Failed to load config from %s: %v", configPath, err)
+		return fmt.Errorf("failed to load config from %s: %w", configPath, err)
+	}
+
+	overrides := ConfigOverrides{
+		Port:      *portFlag,
+		LogLevel:  *logLevelFlag,
+		RecordDir: *recordFlag,
+		ReplayDir: *replayFlag,
 	}
+	overrides.apply(config)
 
 	if err := config.Validate(); err != nil {
-		log.Panicf("Config validation failed: %v", err)
+		return fmt.Errorf("config validation failed: %w", err)
 	}
 
-	// Initialize logger with configured level
-	logLevel := parseLogLevel(config.LogLevel)
-	InitLogger(logLevel)
-	logger := GetLogger()
+	InitLogger(logOptionsFromConfig(config))
+	logger := Log(ComponentConfig)
 
-	logger.Info("Loaded configuration for %d providers", len(config.Providers))
-	for i, provider := range config.Providers {
-		logger.Info("Provider %d: %s with %d models", i+1, provider.Name, len(provider.Models))
+	logger.Info("loaded configuration", "path", configPath, "files", len(config.files), "providers", len(config.Providers))
+	for _, provider := range config.Providers {
+		logger.Info("provider configured", "provider", provider.Name, "models", len(provider.Models))
 	}
 
 	server := NewServer(config, configPath)
+	server.overrides = overrides
 	if err := server.Start(); err != nil {
-		logger.Panic("Failed to start server: %v", err)
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if errors.Is(err, errCheckFailed) {
+			os.Exit(1)
+		}
+		GetLogger().Error("startup failed", "error", err)
+		os.Exit(1)
 	}
 }
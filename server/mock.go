@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderTypeOpenAI = "openai"
+	ProviderTypeMock   = "mock"
+
+	defaultMockChunkSize = 8
+)
+
+// MockConfig shapes the answers of a type: mock provider, which serves
+// completions in-process without any network access. It replies with Text,
+// or echoes the last user message when Text is empty. When ToolCalls are set
+// they are returned first, and the text once the conversation ends with a
+// tool result.
+type MockConfig struct {
+	Text            string         `yaml:"text"`
+	ChunkSize       int            `yaml:"chunkSize"`
+	Delay           time.Duration  `yaml:"delay"`
+	FirstTokenDelay time.Duration  `yaml:"firstTokenDelay"`
+	Status          int            `yaml:"status"`
+	ErrorRate       float64        `yaml:"errorRate"`
+	ErrorMessage    string         `yaml:"errorMessage"`
+	FailAfterChunks int            `yaml:"failAfterChunks"`
+	ToolCalls       []MockToolCall `yaml:"toolCalls"`
+}
+
+// MockToolCall is a tool call scripted into a mock response.
+type MockToolCall struct {
+	Name      string `yaml:"name"`
+	Arguments string `yaml:"arguments"`
+}
+
+func (c *MockConfig) validate() []string {
+	var problems []string
+	if c.ChunkSize < 0 {
+		problems = append(problems, "chunkSize cannot be negative")
+	}
+	if c.Delay < 0 || c.FirstTokenDelay < 0 {
+		problems = append(problems, "delays cannot be negative")
+	}
+	if c.Status != 0 && (c.Status < 400 || c.Status > 599) {
+		problems = append(problems, fmt.Sprintf("status must be an error status between 400 and 599, got %d", c.Status))
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		problems = append(problems, "errorRate must be between 0 and 1")
+	}
+	if c.FailAfterChunks < 0 {
+		problems = append(problems, "failAfterChunks cannot be negative")
+	}
+	for i, call := range c.ToolCalls {
+		if call.Name == "" {
+			problems = append(problems, fmt.Sprintf("toolCalls %d: name cannot be empty", i))
+		}
+		if call.Arguments != "" && !json.Valid([]byte(call.Arguments)) {
+			problems = append(problems, fmt.Sprintf("toolCalls %d: arguments must be JSON", i))
+		}
+	}
+	return problems
+}
+
+// mockTransport answers upstream requests for a mock provider in-process, so
+// that everything from retries to stream handling runs as it would against
+// a real provider.
+type mockTransport struct {
+	settings MockConfig
+	models   []string
+}
+
+func newMockTransport(p *Provider) *mockTransport {
+	t := &mockTransport{models: p.Models}
+	if p.Mock != nil {
+		t.settings = *p.Mock
+	}
+	if t.settings.ChunkSize <= 0 {
+		t.settings.ChunkSize = defaultMockChunkSize
+	}
+	return t
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	if strings.HasSuffix(req.URL.Path, "/models") {
+		return t.modelsResponse(req), nil
+	}
+
+	if t.settings.Status != 0 && (t.settings.ErrorRate == 0 || rand.Float64() < t.settings.ErrorRate) {
+		message := t.settings.ErrorMessage
+		if message == "" {
+			message = http.StatusText(t.settings.Status)
+		}
+		return t.errorResponse(req, t.settings.Status, message), nil
+	}
+
+	var request map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		return t.errorResponse(req, http.StatusBadRequest, "invalid request body: "+err.Error()), nil
+	}
+	var parsed ChatCompletionRequest
+	if err := parsed.FromMap(request); err != nil {
+		return t.errorResponse(req, http.StatusBadRequest, err.Error()), nil
+	}
+
+	body, writer := io.Pipe()
+	go t.stream(req.Context(), writer, &parsed)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"text/event-stream"}},
+		Body:       body,
+		Request:    req,
+	}, nil
+}
+
+func (t *mockTransport) reply(request *ChatCompletionRequest) string {
+	if t.settings.Text != "" {
+		return t.settings.Text
+	}
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		if request.Messages[i].Role == "user" {
+			return request.Messages[i].Text()
+		}
+	}
+	return ""
+}
+
+// stream writes the completion as SSE chunks, honouring the configured
+// delays and failures, and stops when the request is cancelled.
+func (t *mockTransport) stream(ctx context.Context, w *io.PipeWriter, request *ChatCompletionRequest) {
+	id := fmt.Sprintf("mock-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+	sent := 0
+
+	send := func(delta map[string]interface{}, finishReason interface{}) error {
+		delay := t.settings.Delay
+		if sent == 0 {
+			delay = t.settings.FirstTokenDelay
+		}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if t.settings.FailAfterChunks > 0 && sent >= t.settings.FailAfterChunks {
+			return io.ErrUnexpectedEOF
+		}
+
+		chunk := map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   request.Model,
+			"choices": []interface{}{map[string]interface{}{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			}},
+		}
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		sent++
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		return err
+	}
+
+	err := func() error {
+		useTools := len(t.settings.ToolCalls) > 0 &&
+			(len(request.Messages) == 0 || request.Messages[len(request.Messages)-1].Role != "tool")
+
+		if useTools {
+			if err := send(map[string]interface{}{"role": "assistant", "content": nil}, nil); err != nil {
+				return err
+			}
+			for i, call := range t.settings.ToolCalls {
+				if err := send(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+					"index": i,
+					"id":    fmt.Sprintf("call_%d", i),
+					"type":  "function",
+					"function": map[string]interface{}{
+						"name":      call.Name,
+						"arguments": "",
+					},
+				}}}, nil); err != nil {
+					return err
+				}
+				for _, part := range splitChunks(call.Arguments, t.settings.ChunkSize) {
+					if err := send(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+						"index":    i,
+						"function": map[string]interface{}{"arguments": part},
+					}}}, nil); err != nil {
+						return err
+					}
+				}
+			}
+			return send(map[string]interface{}{}, "tool_calls")
+		}
+
+		for i, part := range splitChunks(t.reply(request), t.settings.ChunkSize) {
+			delta := map[string]interface{}{"content": part}
+			if i == 0 {
+				delta["role"] = "assistant"
+			}
+			if err := send(delta, nil); err != nil {
+				return err
+			}
+		}
+		return send(map[string]interface{}{}, "stop")
+	}()
+	if err != nil {
+		w.CloseWithError(err)
+		return
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	w.Close()
+}
+
+// splitChunks splits s into pieces of at most size runes.
+func splitChunks(s string, size int) []string {
+	runes := []rune(s)
+	var parts []string
+	for len(runes) > 0 {
+		n := size
+		if n > len(runes) {
+			n = len(runes)
+		}
+		parts = append(parts, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return parts
+}
+
+func (t *mockTransport) modelsResponse(req *http.Request) *http.Response {
+	data := make([]interface{}, 0, len(t.models))
+	for _, model := range t.models {
+		data = append(data, map[string]interface{}{"id": model, "object": "model", "owned_by": "mock"})
+	}
+	body, _ := json.Marshal(map[string]interface{}{"object": "list", "data": data})
+	return t.response(req, http.StatusOK, "application/json", body)
+}
+
+func (t *mockTransport) errorResponse(req *http.Request, status int, message string) *http.Response {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": "mock_error", "code": status},
+	})
+	return t.response(req, status, "application/json", body)
+}
+
+func (t *mockTransport) response(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
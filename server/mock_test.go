@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMockServer(mock *MockConfig) *Server {
+	return NewServer(&Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:           "mock",
+			Type:           ProviderTypeMock,
+			Mock:           mock,
+			Models:         []string{"m"},
+			CircuitBreaker: &CircuitBreakerConfig{Disabled: true},
+		}},
+	}, "")
+}
+
+func sendMockChat(s *Server, stream bool, messages string) *httptest.ResponseRecorder {
+	body := `{"model":"[mock]m","stream":` + map[bool]string{true: "true", false: "false"}[stream] + `,"messages":` + messages + `}`
+	rec := httptest.NewRecorder()
+	s.ForwardRequest(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	return rec
+}
+
+func TestMockProvider(t *testing.T) {
+	hello := `[{"role":"user","content":"hello mock world"}]`
+
+	t.Run("EchoesInChunks", func(t *testing.T) {
+		rec := sendMockChat(newMockServer(&MockConfig{ChunkSize: 5}), true, hello)
+		out := rec.Body.String()
+		for _, part := range []string{`"content":"hello"`, `"content":" mock"`, `"content":" worl"`, `"content":"d"`} {
+			if !strings.Contains(out, part) {
+				t.Errorf("Expected chunk %s, got: %s", part, out)
+			}
+		}
+		if !strings.Contains(out, `"model":"[mock]m"`) {
+			t.Errorf("Expected chunks relabelled with the client model, got: %s", out)
+		}
+	})
+
+	t.Run("FixedText", func(t *testing.T) {
+		rec := sendMockChat(newMockServer(&MockConfig{Text: "canned"}), true, hello)
+		if !strings.Contains(rec.Body.String(), `"content":"canned"`) {
+			t.Errorf("Expected the fixed text, got: %s", rec.Body.String())
+		}
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		start := time.Now()
+		sendMockChat(newMockServer(&MockConfig{ChunkSize: 4, Delay: 20 * time.Millisecond}), true, hello)
+		if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+			t.Errorf("Expected chunks to be delayed, took %s", elapsed)
+		}
+	})
+
+	t.Run("InjectedStatus", func(t *testing.T) {
+		rec := sendMockChat(newMockServer(&MockConfig{Status: http.StatusServiceUnavailable, ErrorMessage: "overloaded"}), true, hello)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", rec.Code)
+		}
+	})
+
+	t.Run("FailsMidStream", func(t *testing.T) {
+		rec := sendMockChat(newMockServer(&MockConfig{ChunkSize: 2, FailAfterChunks: 2}), true, hello)
+		out := rec.Body.String()
+		if !strings.Contains(out, `"content":"he"`) || !strings.Contains(out, `"content":"ll"`) {
+			t.Errorf("Expected the first chunks before the failure, got: %s", out)
+		}
+		if !strings.Contains(out, `data: {"error":`) {
+			t.Errorf("Expected an SSE error event, got: %s", out)
+		}
+	})
+
+	t.Run("ScriptedToolCalls", func(t *testing.T) {
+		transport := newMockTransport(&Provider{Mock: &MockConfig{
+			Text:      "It is sunny.",
+			ToolCalls: []MockToolCall{{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		}})
+		complete := func(messages string) string {
+			body := `{"model":"m","stream":true,"messages":` + messages + `}`
+			resp, err := (&http.Client{Transport: transport}).Post("http://mock/chat/completions", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			return string(data)
+		}
+
+		out := complete(`[{"role":"user","content":"weather?"}]`)
+		if !strings.Contains(out, `"name":"get_weather"`) || !strings.Contains(out, `"arguments":"{\"city\":`) ||
+			!strings.Contains(out, `"finish_reason":"tool_calls"`) {
+			t.Errorf("Expected a streamed tool call, got: %s", out)
+		}
+
+		out = complete(`[{"role":"user","content":"weather?"},` +
+			`{"role":"assistant","content":null,"tool_calls":[{"id":"call_0","type":"function","function":{"name":"get_weather","arguments":"{}"}}]},` +
+			`{"role":"tool","tool_call_id":"call_0","content":"sunny"}]`)
+		if !strings.Contains(out, `"content":"It is su"`) || strings.Contains(out, "tool_calls") {
+			t.Errorf("Expected the text answer after the tool result, got: %s", out)
+		}
+	})
+
+	t.Run("ServesModels", func(t *testing.T) {
+		provider := &Provider{Name: "mock", Type: ProviderTypeMock, Models: []string{"a", "b"}}
+		uc, err := newUpstreamClient(provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		models, err := discoverProviderModels(context.Background(), uc.client, provider)
+		if err != nil || len(models) != 2 {
+			t.Errorf("Expected the mock to list its models, got %v, %v", models, err)
+		}
+	})
+}
+
+func TestMockProviderValidation(t *testing.T) {
+	config := &Config{Port: 8080, Providers: []Provider{{Name: "mock", Type: ProviderTypeMock, Models: []string{"m"}}}}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected a mock provider without URL or secret to be valid: %v", err)
+	}
+
+	config.Providers[0].Mock = &MockConfig{Status: 200, ToolCalls: []MockToolCall{{Name: "f", Arguments: "{"}}}
+	err := config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "status must be") || !strings.Contains(err.Error(), "arguments must be JSON") {
+		t.Errorf("Expected mock settings to be validated, got %v", err)
+	}
+
+	config.Providers[0].Type = "bogus"
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "type must be") {
+		t.Errorf("Expected an unknown type to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Model features that can be declared under modelInfo.features.
+const (
+	FeatureTools     = "tools"
+	FeatureVision    = "vision"
+	FeatureReasoning = "reasoning"
+	FeatureFIM       = "fim"
+)
+
+var knownFeatures = []string{FeatureTools, FeatureVision, FeatureReasoning, FeatureFIM}
+
+// ModelInfo is the per-model metadata from a provider's modelInfo section.
+// Zero values mean unknown; a nil Features list means the capabilities are
+// unknown and requests are not checked against them.
+type ModelInfo struct {
+	ContextWindow   int           `yaml:"contextWindow" json:"context_window,omitempty"`
+	MaxOutputTokens int           `yaml:"maxOutputTokens" json:"max_output_tokens,omitempty"`
+	Features        []string      `yaml:"features" json:"features,omitempty"`
+	Pricing         *ModelPricing `yaml:"pricing" json:"pricing,omitempty"`
+	// Tokenizer names a registered tokenizer for usage estimates and context
+	// window checks, default bpe-approx.
+	Tokenizer string `yaml:"tokenizer" json:"-"`
+	// ContextPolicy overrides context.policy for requests that overflow
+	// ContextWindow.
+	ContextPolicy string `yaml:"contextPolicy" json:"-"`
+}
+
+// ModelPricing is the price per million tokens.
+type ModelPricing struct {
+	Input    float64 `yaml:"input" json:"input"`
+	Output   float64 `yaml:"output" json:"output"`
+	Currency string  `yaml:"currency" json:"currency,omitempty"`
+}
+
+func (m *ModelInfo) supports(feature string) bool {
+	if m.Features == nil {
+		return true
+	}
+	for _, f := range m.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ModelInfo) validate() []string {
+	var problems []string
+	if m.ContextWindow < 0 {
+		problems = append(problems, "contextWindow cannot be negative")
+	}
+	if m.MaxOutputTokens < 0 {
+		problems = append(problems, "maxOutputTokens cannot be negative")
+	}
+	for _, feature := range m.Features {
+		known := false
+		for _, k := range knownFeatures {
+			if feature == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("unknown feature %q", feature))
+		}
+	}
+	if !validContextPolicy(m.ContextPolicy) {
+		problems = append(problems, fmt.Sprintf("contextPolicy must be reject, truncate or summarize, got %q", m.ContextPolicy))
+	}
+	if m.Tokenizer != "" {
+		if _, ok := lookupTokenizer(m.Tokenizer); !ok {
+			problems = append(problems, fmt.Sprintf("unknown tokenizer %q, known: %s", m.Tokenizer, strings.Join(tokenizerNames(), ", ")))
+		}
+	}
+	return problems
+}
+
+// lookupModelInfo returns the metadata for a model. Exact keys win over glob
+// keys; glob keys are tried in sorted order.
+func (p *Provider) lookupModelInfo(model string) *ModelInfo {
+	if info, ok := p.ModelInfo[model]; ok {
+		return &info
+	}
+
+	patterns := make([]string, 0, len(p.ModelInfo))
+	for pattern := range p.ModelInfo {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matchGlob(pattern, model) {
+			info := p.ModelInfo[pattern]
+			return &info
+		}
+	}
+	return nil
+}
+
+// describeModel builds the /v1/models entry for one of the provider's models.
+func (p *Provider) describeModel(model, source string) Model {
+	entry := Model{
+		ID:      "[" + p.Name + "]" + model,
+		Object:  "model",
+		OwnedBy: p.Name,
+		Source:  source,
+	}
+	if info := p.lookupModelInfo(model); info != nil {
+		entry.ModelInfo = *info
+	}
+	return entry
+}
+
+// capabilityError is a request rejected before forwarding because the model
+// cannot serve it.
+type capabilityError struct {
+	code    string
+	message string
+}
+
+func (e *capabilityError) Error() string {
+	return e.message
+}
+
+// checkRequest rejects requests the model is known not to support.
+func (m *ModelInfo) checkRequest(model string, request *ChatCompletionRequest) *capabilityError {
+	if !m.supports(FeatureVision) {
+		for i := range request.Messages {
+			if request.Messages[i].HasImage() {
+				return &capabilityError{"model_not_supported", fmt.Sprintf("model %s does not support image input", model)}
+			}
+		}
+	}
+
+	if !m.supports(FeatureTools) && (len(getSlice(request.Extra, "tools")) > 0 || len(getSlice(request.Extra, "functions")) > 0) {
+		return &capabilityError{"model_not_supported", fmt.Sprintf("model %s does not support tool calls", model)}
+	}
+
+	if !m.supports(FeatureReasoning) && (request.Extra["reasoning_effort"] != nil || request.Extra["reasoning"] != nil) {
+		return &capabilityError{"model_not_supported", fmt.Sprintf("model %s does not support reasoning options", model)}
+	}
+
+	maxTokens := requestedMaxTokens(request)
+	if m.MaxOutputTokens > 0 && maxTokens > m.MaxOutputTokens {
+		return &capabilityError{"invalid_value", fmt.Sprintf("max_tokens %d exceeds the %d output tokens supported by model %s", maxTokens, m.MaxOutputTokens, model)}
+	}
+
+	if m.ContextWindow > 0 {
+		promptTokens := countPromptTokens(tokenizerFor(m), request.Messages)
+		if promptTokens+maxTokens > m.ContextWindow {
+			return &capabilityError{"context_length_exceeded", fmt.Sprintf("request needs about %d tokens (%d prompt, %d completion) but model %s has a context window of %d", promptTokens+maxTokens, promptTokens, maxTokens, model, m.ContextWindow)}
+		}
+	}
+
+	return nil
+}
+
+// writeOpenAIError writes an error in the OpenAI API error format.
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	body := map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"code":    code,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		GetLogger().Error("failed to encode error response", "error", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModelInfoCheckRequest(t *testing.T) {
+	info := &ModelInfo{
+		ContextWindow:   100,
+		MaxOutputTokens: 50,
+		Features:        []string{FeatureTools},
+	}
+
+	parse := func(t *testing.T, body string) *ChatCompletionRequest {
+		t.Helper()
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &data); err != nil {
+			t.Fatalf("Failed to parse request: %v", err)
+		}
+		var request ChatCompletionRequest
+		request.FromMap(data)
+		return &request
+	}
+
+	t.Run("Accepted", func(t *testing.T) {
+		request := parse(t, `{"messages":[{"role":"user","content":"hi"}],"tools":[{"type":"function"}]}`)
+		if err := info.checkRequest("m", request); err != nil {
+			t.Errorf("Expected request to be accepted, got: %v", err)
+		}
+	})
+
+	t.Run("ImageOnTextModel", func(t *testing.T) {
+		request := parse(t, `{"messages":[{"role":"user","content":[{"type":"text","text":"what is this"},{"type":"image_url","image_url":{"url":"data:"}}]}]}`)
+		err := info.checkRequest("m", request)
+		if err == nil || err.code != "model_not_supported" {
+			t.Errorf("Expected model_not_supported, got: %v", err)
+		}
+	})
+
+	t.Run("MaxTokens", func(t *testing.T) {
+		request := parse(t, `{"messages":[{"role":"user","content":"hi"}],"max_tokens":60}`)
+		if err := info.checkRequest("m", request); err == nil || err.code != "invalid_value" {
+			t.Errorf("Expected invalid_value, got: %v", err)
+		}
+	})
+
+	t.Run("ContextLength", func(t *testing.T) {
+		request := parse(t, `{"messages":[{"role":"user","content":"`+strings.Repeat("word ", 100)+`"}]}`)
+		if err := info.checkRequest("m", request); err == nil || err.code != "context_length_exceeded" {
+			t.Errorf("Expected context_length_exceeded, got: %v", err)
+		}
+	})
+
+	t.Run("UnknownFeaturesAreNotChecked", func(t *testing.T) {
+		request := parse(t, `{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"data:"}}]}]}`)
+		if err := (&ModelInfo{}).checkRequest("m", request); err != nil {
+			t.Errorf("Expected request to be accepted, got: %v", err)
+		}
+	})
+}
+
+func TestChatMessageRoundTrip(t *testing.T) {
+	body := `{"model":"m","messages":[{"role":"assistant","content":"","tool_calls":[{"id":"call_1"}]},{"role":"user","content":[{"type":"text","text":"a"},{"type":"text","text":"b"}]}]}`
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	var request ChatCompletionRequest
+	request.FromMap(data)
+
+	if got := request.Messages[1].Text(); got != "ab" {
+		t.Errorf("Expected text 'ab', got '%s'", got)
+	}
+
+	out, err := json.Marshal(request.ToMap())
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	if !strings.Contains(string(out), `"tool_calls":[{"id":"call_1"}]`) {
+		t.Errorf("Expected tool_calls to be preserved, got: %s", out)
+	}
+	if !strings.Contains(string(out), `"content":[{"text":"a","type":"text"}`) {
+		t.Errorf("Expected content parts to be preserved, got: %s", out)
+	}
+}
+
+func TestModelHandler(t *testing.T) {
+	config := &Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:   "test",
+			URL:    "https://example.com",
+			Secret: "sk",
+			Models: []string{"org/model-a"},
+			ModelInfo: map[string]ModelInfo{
+				"org/*": {ContextWindow: 128000, Features: []string{FeatureVision}},
+			},
+		}},
+	}
+	s := NewServer(config, "")
+
+	t.Run("Found", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		s.ModelHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/models/[test]org/model-a", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		var model map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &model)
+		if model["owned_by"] != "test" {
+			t.Errorf("Expected owned_by 'test', got %v", model["owned_by"])
+		}
+		if model["context_window"] != float64(128000) {
+			t.Errorf("Expected context_window 128000, got %v", model["context_window"])
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		s.ModelHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/models/[test]missing", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+}
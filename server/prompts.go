@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// clientHeader names the calling application for prompt policies. Clients
+// that do not send it are identified by their User-Agent.
+const clientHeader = "X-Local-Router-Client"
+
+// PromptPolicy adds system messages to matching requests. Every selector is
+// a list of globs; an empty list matches everything. Prepend and Append are
+// text/template templates, see promptVars.
+type PromptPolicy struct {
+	Providers []string `yaml:"providers"`
+	// Models match the upstream model name, Aliases the model name as the
+	// client sent it, such as "[zhipu]GLM-4.6".
+	Models  []string `yaml:"models"`
+	Aliases []string `yaml:"aliases"`
+	Clients []string `yaml:"clients"`
+
+	Prepend string `yaml:"prepend"`
+	Append  string `yaml:"append"`
+	// MergeSystem folds every system message into a single one at the start,
+	// for models that accept only one.
+	MergeSystem bool `yaml:"mergeSystem"`
+}
+
+// promptVars are the variables available to prompt templates.
+type promptVars struct {
+	Date     string // 2006-01-02, local time
+	Time     string // 15:04, local time
+	Client   string
+	Provider string
+	Model    string
+	Alias    string
+}
+
+func (p *PromptPolicy) validate() []string {
+	var problems []string
+	if p.Prepend == "" && p.Append == "" && !p.MergeSystem {
+		problems = append(problems, "one of prepend, append or mergeSystem is required")
+	}
+	// Rendering with empty variables catches unknown variables as well as
+	// syntax errors.
+	if _, err := renderPrompt(p.Prepend, &promptVars{}); err != nil {
+		problems = append(problems, fmt.Sprintf("prepend: %v", err))
+	}
+	if _, err := renderPrompt(p.Append, &promptVars{}); err != nil {
+		problems = append(problems, fmt.Sprintf("append: %v", err))
+	}
+	return problems
+}
+
+func (p *PromptPolicy) matches(vars *promptVars) bool {
+	return matchAnyGlob(p.Providers, vars.Provider) &&
+		matchAnyGlob(p.Models, vars.Model) &&
+		matchAnyGlob(p.Aliases, vars.Alias) &&
+		matchAnyGlob(p.Clients, vars.Client)
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientName identifies the caller of a request for prompt policies.
+func clientName(r *http.Request) string {
+	if name := r.Header.Get(clientHeader); name != "" {
+		return name
+	}
+	return r.UserAgent()
+}
+
+func (s *Server) promptPolicies() []PromptPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Prompts
+}
+
+// applyPromptPolicies adds the system messages of every matching policy,
+// keeping config order among the prepended and among the appended messages,
+// and merges system messages if any matching policy asks for it.
+func applyPromptPolicies(policies []PromptPolicy, messages []ChatMessage, vars *promptVars) ([]ChatMessage, error) {
+	logger := Log(ComponentRouting)
+	var prepended, appended []ChatMessage
+	merge := false
+	for i := range policies {
+		policy := &policies[i]
+		if !policy.matches(vars) {
+			continue
+		}
+		if policy.Prepend != "" {
+			text, err := renderPrompt(policy.Prepend, vars)
+			if err != nil {
+				return nil, fmt.Errorf("prompt policy %d prepend: %w", i, err)
+			}
+			prepended = append(prepended, ChatMessage{Role: "system", Content: text})
+		}
+		if policy.Append != "" {
+			text, err := renderPrompt(policy.Append, vars)
+			if err != nil {
+				return nil, fmt.Errorf("prompt policy %d append: %w", i, err)
+			}
+			appended = append(appended, ChatMessage{Role: "system", Content: text})
+		}
+		merge = merge || policy.MergeSystem
+		logger.Debug("applied prompt policy", "policy", i, "provider", vars.Provider, "model", vars.Model, "client", vars.Client)
+	}
+
+	if len(prepended) > 0 || len(appended) > 0 {
+		combined := make([]ChatMessage, 0, len(prepended)+len(messages)+len(appended))
+		combined = append(combined, prepended...)
+		combined = append(combined, messages...)
+		messages = append(combined, appended...)
+	}
+	if merge {
+		messages = mergeSystemMessages(messages)
+	}
+	return messages, nil
+}
+
+func renderPrompt(text string, vars *promptVars) (string, error) {
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// mergeSystemMessages joins the text of every system message, separated by
+// blank lines, into one system message at the start of the conversation.
+func mergeSystemMessages(messages []ChatMessage) []ChatMessage {
+	var texts []string
+	rest := make([]ChatMessage, 0, len(messages))
+	for i := range messages {
+		if messages[i].Role == "system" {
+			if text := messages[i].Text(); text != "" {
+				texts = append(texts, text)
+			}
+			continue
+		}
+		rest = append(rest, messages[i])
+	}
+	if len(texts) == 0 {
+		return rest
+	}
+	return append([]ChatMessage{{Role: "system", Content: strings.Join(texts, "\n\n")}}, rest...)
+}
+
+func newPromptVars(r *http.Request, provider *Provider, model, alias string) *promptVars {
+	now := time.Now()
+	return &promptVars{
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("15:04"),
+		Client:   clientName(r),
+		Provider: provider.Name,
+		Model:    model,
+		Alias:    alias,
+	}
+}
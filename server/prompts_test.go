@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyPromptPolicies(t *testing.T) {
+	vars := &promptVars{Date: "2026-01-02", Client: "cli", Provider: "zhipu", Model: "GLM-4.6", Alias: "[zhipu]GLM-4.6"}
+	messages := []ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "hi"},
+	}
+
+	t.Run("PrependAndAppend", func(t *testing.T) {
+		policies := []PromptPolicy{
+			{Models: []string{"GLM-*"}, Prepend: "Today is {{.Date}}."},
+			{Clients: []string{"other"}, Prepend: "not applied"},
+			{Providers: []string{"zhipu"}, Prepend: "Client {{.Client}} via {{.Alias}}.", Append: "Answer in English."},
+		}
+		got, err := applyPromptPolicies(policies, messages, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"Today is 2026-01-02.", "Client cli via [zhipu]GLM-4.6.", "Be brief.", "hi", "Answer in English."}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %d messages, got %+v", len(want), got)
+		}
+		for i := range want {
+			if got[i].Content != want[i] {
+				t.Errorf("Message %d: expected %q, got %q", i, want[i], got[i].Content)
+			}
+		}
+		if len(messages) != 2 {
+			t.Error("Expected the original messages to be left alone")
+		}
+	})
+
+	t.Run("MergeSystem", func(t *testing.T) {
+		policies := []PromptPolicy{
+			{Append: "Answer in English."},
+			{Aliases: []string{"[zhipu]*"}, MergeSystem: true},
+		}
+		got, err := applyPromptPolicies(policies, messages, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Role != "system" || got[0].Content != "Be brief.\n\nAnswer in English." || got[1].Content != "hi" {
+			t.Errorf("Expected a single merged system message first, got %+v", got)
+		}
+	})
+
+	t.Run("NoMatch", func(t *testing.T) {
+		got, err := applyPromptPolicies([]PromptPolicy{{Models: []string{"gpt-*"}, Prepend: "x"}}, messages, vars)
+		if err != nil || len(got) != 2 {
+			t.Errorf("Expected the messages unchanged, got %+v, %v", got, err)
+		}
+	})
+}
+
+func TestPromptPolicyValidation(t *testing.T) {
+	config := &Config{
+		Port:      8080,
+		Providers: []Provider{{Name: "a", URL: "http://a", Secret: "sk", Models: []string{"m"}}},
+		Prompts: []PromptPolicy{
+			{Models: []string{"m"}},
+			{Prepend: "{{.Unknown}}"},
+			{Append: "{{.Date"},
+		},
+	}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Expected invalid prompt policies to be rejected")
+	}
+	for _, want := range []string{"prompts 0: one of prepend, append or mergeSystem is required", "prompts 1: prepend:", "prompts 2: append:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+}
+
+func TestPromptPoliciesRouting(t *testing.T) {
+	upstream := newFakeUpstream(t, contentChunk("ok"))
+	s, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}})
+	s.config.Prompts = []PromptPolicy{{
+		Clients: []string{"editor*"},
+		Prepend: "{{.Client}} asked {{.Model}} on {{.Date}}.",
+	}}
+
+	resp := postChat(t, context.Background(), router, chatBody("[up]m", true), http.Header{clientHeader: {"editor-1"}})
+	resp.Body.Close()
+
+	_, forwarded := upstream.received()
+	messages, _ := forwarded["messages"].([]interface{})
+	if len(messages) != 2 {
+		t.Fatalf("Expected a system message to be added, got %v", forwarded["messages"])
+	}
+	first, _ := messages[0].(map[string]interface{})
+	want := "editor-1 asked m on " + time.Now().Format("2006-01-02") + "."
+	if first["role"] != "system" || first["content"] != want {
+		t.Errorf("Expected system message %q, got %v", want, first)
+	}
+
+	resp = postChat(t, context.Background(), router, chatBody("[up]m", true), nil)
+	resp.Body.Close()
+	_, forwarded = upstream.received()
+	if messages, _ := forwarded["messages"].([]interface{}); len(messages) != 1 {
+		t.Errorf("Expected other clients to be left alone, got %v", forwarded["messages"])
+	}
+}
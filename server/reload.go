@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	configPollInterval = time.Second
+	configDebounce     = 500 * time.Millisecond
+)
+
+// ErrInvalidConfig is wrapped by reload errors caused by validation rather
+// than by reading or parsing the file.
+var ErrInvalidConfig = errors.New("config validation failed")
+
+// Reload loads and validates the config file, then swaps it in. In-flight
+// requests keep the provider they already resolved; new requests see the
+// new config. Reloads are serialized.
+func (s *Server) Reload(source string) (*Config, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.reload(source, nil)
+}
+
+// reload loads the config with the files in pending, keyed by absolute path,
+// replaced by their new contents, and writes those files only once the
+// result is valid. Callers must hold s.reloadMu.
+func (s *Server) reload(source string, pending map[string][]byte) (*Config, error) {
+	logger := Log(ComponentConfig)
+
+	newConfig, err := loadConfigWith(s.configPath, pending)
+	if err != nil {
+		logger.Error("failed to reload config", "source", source, "path", s.configPath, "error", err)
+		return nil, err
+	}
+	s.overrides.apply(newConfig)
+
+	if err := newConfig.Validate(); err != nil {
+		logger.Error("config validation failed during reload", "source", source, "error", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	for path, data := range pending {
+		if err := writeFileAtomic(path, data); err != nil {
+			logger.Error("failed to write config file", "source", source, "path", path, "error", err)
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	listenChanged := !reflect.DeepEqual(s.config.listenAddresses(), newConfig.listenAddresses())
+	s.mu.RUnlock()
+	if listenChanged {
+		logger.Warn("listen addresses changed, restart to apply", "source", source)
+	}
+
+	s.applyConfig(newConfig)
+	s.discovery.refresh()
+	InitLogger(logOptionsFromConfig(newConfig))
+	Log(ComponentConfig).Info("reloaded configuration", "source", source, "providers", len(newConfig.Providers))
+	return newConfig, nil
+}
+
+func (s *Server) applyConfig(config *Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	s.initLimiters()
+	s.initBreakers()
+	s.initClients()
+	s.initCache()
+	s.initProviderStats()
+}
+
+// watchConfig polls the config file and its includes and reloads once they
+// have stopped changing for configDebounce.
+func (s *Server) watchConfig(ctx context.Context) {
+	last := s.statConfigFiles()
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	var pending *time.Timer
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			if pending != nil {
+				pending.Stop()
+			}
+			return
+		case <-ticker.C:
+			current := s.statConfigFiles()
+			if current == last {
+				continue
+			}
+			last = current
+			if pending != nil {
+				pending.Stop()
+			}
+			pending = time.NewTimer(configDebounce)
+			fire = pending.C
+		case <-fire:
+			pending, fire = nil, nil
+			s.Reload("watch")
+		}
+	}
+}
+
+// statConfigFiles summarizes the modification state of every loaded config
+// file so that a change to any of them can be detected.
+func (s *Server) statConfigFiles() string {
+	s.mu.RLock()
+	files := s.config.files
+	s.mu.RUnlock()
+	if len(files) == 0 {
+		files = []string{s.configPath}
+	}
+
+	var b strings.Builder
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(&b, "%s:missing;", path)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String()
+}
+
+// handleSIGHUP reloads the config each time the process receives SIGHUP.
+func (s *Server) handleSIGHUP(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			s.Reload("sighup")
+		}
+	}
+}
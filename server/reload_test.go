@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const reloadTestConfig = `port: 8080
+providers:
+  - name: a
+    url: https://a.example.com
+    secret: sk
+    concurrentLimit: %s
+    models: [m1]
+  - name: b
+    url: https://b.example.com
+    secret: sk
+    concurrentLimit: 2
+    models: [m2]
+`
+
+func writeTestConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, sprintfConfig("1"))
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	s := NewServer(config, path)
+	oldA, oldB := s.limiters["a"], s.limiters["b"]
+
+	t.Run("KeepsUnchangedLimiters", func(t *testing.T) {
+		writeTestConfig(t, path, sprintfConfig("3"))
+		if _, err := s.Reload("test"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if s.limiters["b"] != oldB {
+			t.Error("Expected limiter for unchanged provider b to be kept")
+		}
+		if s.limiters["a"] == oldA || cap(s.limiters["a"]) != 3 {
+			t.Error("Expected limiter for provider a to be replaced with capacity 3")
+		}
+	})
+
+	t.Run("RemovesDisabledLimiters", func(t *testing.T) {
+		writeTestConfig(t, path, sprintfConfig("0"))
+		if _, err := s.Reload("test"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if _, ok := s.limiters["a"]; ok {
+			t.Error("Expected limiter for provider a to be removed")
+		}
+	})
+
+	t.Run("InvalidConfigKeepsOld", func(t *testing.T) {
+		before := s.config
+		writeTestConfig(t, path, "port: 0\n")
+		_, err := s.Reload("test")
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("Expected ErrInvalidConfig, got: %v", err)
+		}
+		if s.config != before {
+			t.Error("Expected config to be unchanged after failed reload")
+		}
+	})
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, sprintfConfig("1"))
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	s := NewServer(config, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.watchConfig(ctx)
+	// Let the watcher record the initial state before changing the file.
+	time.Sleep(100 * time.Millisecond)
+
+	writeTestConfig(t, path, sprintfConfig("10"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.RLock()
+		limit := s.config.Providers[0].ConcurrentLimit
+		s.mu.RUnlock()
+		if limit == 10 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("Expected config change to be picked up by watcher")
+}
+
+func sprintfConfig(limit string) string {
+	return fmt.Sprintf(reloadTestConfig, limit)
+}
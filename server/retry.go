@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultMultiplier     = 2
+	defaultJitter         = 0.2
+
+	// maxRetryDrain bounds how much of a retried response is read so that
+	// its connection can be reused.
+	maxRetryDrain = 64 << 10
+)
+
+var defaultRetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryConfig controls how failed upstream calls are retried. Retries only
+// happen before anything has been written to the client, so a stream that
+// fails midway is never replayed.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	Jitter         float64       `yaml:"jitter"`
+	RetryOn        []int         `yaml:"retryOn"`
+}
+
+func (c *RetryConfig) withDefaults() RetryConfig {
+	var settings RetryConfig
+	if c != nil {
+		settings = *c
+	}
+	if settings.MaxAttempts <= 0 {
+		settings.MaxAttempts = 1
+	}
+	if settings.InitialBackoff <= 0 {
+		settings.InitialBackoff = defaultInitialBackoff
+	}
+	if settings.MaxBackoff <= 0 {
+		settings.MaxBackoff = defaultMaxBackoff
+	}
+	if settings.Multiplier < 1 {
+		settings.Multiplier = defaultMultiplier
+	}
+	if settings.Jitter <= 0 {
+		settings.Jitter = defaultJitter
+	}
+	if len(settings.RetryOn) == 0 {
+		settings.RetryOn = defaultRetryStatuses
+	}
+	return settings
+}
+
+func (c *RetryConfig) validate() []string {
+	var problems []string
+	if c.MaxAttempts < 0 {
+		problems = append(problems, "maxAttempts cannot be negative")
+	}
+	if c.InitialBackoff < 0 || c.MaxBackoff < 0 {
+		problems = append(problems, "backoff durations cannot be negative")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		problems = append(problems, "jitter must be between 0 and 1")
+	}
+	for _, status := range c.RetryOn {
+		if status < 100 || status > 599 {
+			problems = append(problems, fmt.Sprintf("retryOn: invalid status %d", status))
+		}
+	}
+	return problems
+}
+
+func (c *RetryConfig) retryable(statusCode int) bool {
+	for _, status := range c.RetryOn {
+		if status == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the jittered delay before the given retry, counting from 1.
+func (c *RetryConfig) backoff(retry int) time.Duration {
+	delay := float64(c.InitialBackoff) * math.Pow(c.Multiplier, float64(retry-1))
+	if delay > float64(c.MaxBackoff) {
+		delay = float64(c.MaxBackoff)
+	}
+	delay *= 1 + c.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date. It returns false when the header is absent or malformed.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sendWithRetry sends the request built by newRequest, retrying transport
+// errors and retryable statuses with exponential backoff. A Retry-After
+// header longer than maxBackoff ends the retries so the client sees the
+// provider's answer. The last response or error is returned.
+func sendWithRetry(ctx context.Context, client *http.Client, provider *Provider, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	settings := provider.Retry.withDefaults()
+	logger := Log(ComponentRouting)
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		err = redactURLError(err)
+		if ctx.Err() != nil || attempt >= settings.MaxAttempts {
+			return resp, err
+		}
+
+		delay := settings.backoff(attempt)
+		if err != nil {
+			logger.Warn("upstream attempt failed, retrying", "provider", provider.Name, "attempt", attempt, "max_attempts", settings.MaxAttempts, "error", err, "backoff", delay)
+		} else {
+			if !settings.retryable(resp.StatusCode) {
+				return resp, nil
+			}
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if retryAfter > settings.MaxBackoff {
+					logger.Warn("upstream asked to retry later than maxBackoff, giving up", "provider", provider.Name, "attempt", attempt, "retry_after", retryAfter)
+					return resp, nil
+				}
+				if retryAfter > delay {
+					delay = retryAfter
+				}
+			}
+			logger.Warn("upstream attempt failed, retrying", "provider", provider.Name, "attempt", attempt, "max_attempts", settings.MaxAttempts, "status", resp.StatusCode, "backoff", delay)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxRetryDrain))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
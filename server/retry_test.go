@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	var hits atomic.Int32
+	var failures atomic.Int32
+	var status atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failures.Add(-1) >= 0 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "busy", int(status.Load()))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	config := &Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:   "p",
+			URL:    upstream.URL,
+			Secret: "sk",
+			Models: []string{"m"},
+			Retry: &RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     10 * time.Millisecond,
+			},
+		}},
+	}
+	s := NewServer(config, "")
+
+	cases := []struct {
+		name     string
+		failures int32
+		status   int32
+		wantCode int
+		wantHits int32
+	}{
+		{"RecoversAfterRetries", 2, http.StatusServiceUnavailable, http.StatusOK, 3},
+		{"GivesUpAfterMaxAttempts", 5, http.StatusTooManyRequests, http.StatusTooManyRequests, 3},
+		{"DoesNotRetryOtherStatuses", 1, http.StatusInternalServerError, http.StatusInternalServerError, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hits.Store(0)
+			failures.Store(c.failures)
+			status.Store(c.status)
+
+			rec := sendChat(s, "[p]m")
+			if rec.Code != c.wantCode {
+				t.Errorf("Expected status %d, got %d", c.wantCode, rec.Code)
+			}
+			if hits.Load() != c.wantHits {
+				t.Errorf("Expected %d upstream hits, got %d", c.wantHits, hits.Load())
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if d, ok := parseRetryAfter("3", now); !ok || d != 3*time.Second {
+		t.Errorf("Expected 3s, got %v %v", d, ok)
+	}
+	if d, ok := parseRetryAfter(now.Add(5*time.Second).Format(http.TimeFormat), now); !ok || d != 5*time.Second {
+		t.Errorf("Expected 5s, got %v %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon", now); ok {
+		t.Error("Expected malformed value to be rejected")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	settings := (&RetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.1}).withDefaults()
+
+	for retry, base := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second} {
+		d := settings.backoff(retry)
+		if d < base*9/10 || d > base*11/10 {
+			t.Errorf("Expected backoff for retry %d near %v, got %v", retry, base, d)
+		}
+	}
+}
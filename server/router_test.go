@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeUpstream is an OpenAI-compatible provider that streams scripted SSE
+// events and records what it received.
+type fakeUpstream struct {
+	*httptest.Server
+
+	// handle, when set, replaces the default scripted stream.
+	handle func(w http.ResponseWriter, r *http.Request)
+	events []string
+	delay  time.Duration
+
+	mu          sync.Mutex
+	lastHeader  http.Header
+	lastRequest map[string]interface{}
+
+	active    atomic.Int32
+	maxActive atomic.Int32
+	cancelled atomic.Int32
+}
+
+func newFakeUpstream(t *testing.T, events ...string) *fakeUpstream {
+	u := &fakeUpstream{events: events}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		active := u.active.Add(1)
+		defer u.active.Add(-1)
+		for {
+			max := u.maxActive.Load()
+			if active <= max || u.maxActive.CompareAndSwap(max, active) {
+				break
+			}
+		}
+
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		u.mu.Lock()
+		u.lastHeader = r.Header.Clone()
+		u.lastRequest = request
+		u.mu.Unlock()
+
+		if u.handle != nil {
+			u.handle(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Request-Id", "upstream-1")
+		for _, event := range u.events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			w.(http.Flusher).Flush()
+			if u.delay > 0 {
+				select {
+				case <-r.Context().Done():
+					u.cancelled.Add(1)
+					return
+				case <-time.After(u.delay):
+				}
+			}
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *fakeUpstream) received() (http.Header, map[string]interface{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.lastHeader, u.lastRequest
+}
+
+func contentChunk(content string) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"upstream-model","choices":[{"index":0,"delta":{"content":%q}}]}`, content)
+}
+
+// newRouter serves the router over real HTTP in front of the given providers.
+func newRouter(t *testing.T, providers ...Provider) (*Server, *httptest.Server) {
+	for i := range providers {
+		if providers[i].Secret == "" {
+			providers[i].Secret = "sk-" + providers[i].Name
+		}
+		if providers[i].CircuitBreaker == nil {
+			providers[i].CircuitBreaker = &CircuitBreakerConfig{Disabled: true}
+		}
+	}
+	s := NewServer(&Config{Port: 8080, Providers: providers}, "")
+	router := httptest.NewServer(s.SetupRoutes())
+	t.Cleanup(router.Close)
+	return s, router
+}
+
+func postChat(t *testing.T, ctx context.Context, router *httptest.Server, body string, header http.Header) *http.Response {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, router.URL+"/v1/chat/completions", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := router.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func chatBody(model string, stream bool) string {
+	return fmt.Sprintf(`{"model":%q,"stream":%t,"messages":[{"role":"user","content":"hi"}]}`, model, stream)
+}
+
+// readEvents returns the data payloads of an SSE response.
+func readEvents(t *testing.T, body io.Reader) []string {
+	var events []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	return events
+}
+
+func TestRouterStreaming(t *testing.T) {
+	upstream := newFakeUpstream(t, contentChunk("Hel"), contentChunk("lo"))
+	_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"upstream-model"}})
+
+	resp := postChat(t, context.Background(), router, chatBody("[up]upstream-model", true), nil)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", ct)
+	}
+
+	events := readEvents(t, resp.Body)
+	if len(events) != 4 || events[3] != "[DONE]" {
+		t.Fatalf("Expected 2 chunks, a usage chunk and [DONE], got %v", events)
+	}
+	for _, event := range events[:2] {
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(event), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %s: %v", event, err)
+		}
+		if chunk["model"] != "[up]upstream-model" {
+			t.Errorf("Expected chunks relabelled with the client model, got %v", chunk["model"])
+		}
+	}
+
+	_, forwarded := upstream.received()
+	if forwarded["model"] != "upstream-model" || forwarded["stream"] != true {
+		t.Errorf("Expected the upstream model and a streaming request, got %v", forwarded)
+	}
+}
+
+func TestRouterNonStreaming(t *testing.T) {
+	upstream := newFakeUpstream(t,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"upstream-model","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		contentChunk("lo"),
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"upstream-model","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"upstream-model","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+	)
+	_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"upstream-model"}})
+
+	resp := postChat(t, context.Background(), router, chatBody("[up]upstream-model", false), nil)
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON, got %q", ct)
+	}
+	var completion struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage map[string]float64 `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		t.Fatal(err)
+	}
+
+	if completion.ID != "chatcmpl-1" || completion.Object != "chat.completion" || completion.Model != "[up]upstream-model" {
+		t.Errorf("Unexpected completion envelope: %+v", completion)
+	}
+	if len(completion.Choices) != 1 {
+		t.Fatalf("Expected 1 choice, got %d", len(completion.Choices))
+	}
+	choice := completion.Choices[0]
+	if choice.Message.Role != "assistant" || choice.Message.Content != "Hello" || choice.FinishReason != "stop" {
+		t.Errorf("Expected the assembled message, got %+v", choice)
+	}
+	if completion.Usage["total_tokens"] != 5 {
+		t.Errorf("Expected usage to be kept, got %v", completion.Usage)
+	}
+}
+
+func TestRouterHeaderForwarding(t *testing.T) {
+	upstream := newFakeUpstream(t, contentChunk("ok"))
+	_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Secret: "sk-provider", Models: []string{"m"}})
+
+	header := http.Header{
+		"Authorization":   {"Bearer client-key"},
+		"X-Trace":         {"abc"},
+		"Accept-Encoding": {"br"},
+	}
+	resp := postChat(t, context.Background(), router, chatBody("[up]m", true), header)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	received, _ := upstream.received()
+	if got := received.Get("Authorization"); got != "Bearer sk-provider" {
+		t.Errorf("Expected the provider secret upstream, got %q", got)
+	}
+	if got := received.Get("X-Trace"); got != "abc" {
+		t.Errorf("Expected custom headers to be forwarded, got %q", got)
+	}
+	if got := received.Get("Accept-Encoding"); got == "br" {
+		t.Error("Expected the client's Accept-Encoding not to be forwarded")
+	}
+	if got := resp.Header.Get("X-Request-Id"); got != "upstream-1" {
+		t.Errorf("Expected upstream response headers to be forwarded, got %q", got)
+	}
+}
+
+func TestRouterToolCalls(t *testing.T) {
+	upstream := newFakeUpstream(t,
+		`{"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"get_time","arguments":"{}"}}]}}]}`,
+		`{"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	)
+	_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}})
+
+	t.Run("Streaming", func(t *testing.T) {
+		resp := postChat(t, context.Background(), router, chatBody("[up]m", true), nil)
+		defer resp.Body.Close()
+		events := readEvents(t, resp.Body)
+		if len(events) != 7 {
+			t.Fatalf("Expected 5 chunks, a usage chunk and [DONE], got %v", events)
+		}
+		if !strings.Contains(events[0], `"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"arguments":"","name":"get_weather"}}]`) {
+			t.Errorf("Expected the first tool call fragment to be relayed, got %s", events[0])
+		}
+		if !strings.Contains(events[1], `"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]`) {
+			t.Errorf("Expected continuation fragments without id or type, got %s", events[1])
+		}
+	})
+
+	t.Run("NonStreaming", func(t *testing.T) {
+		resp := postChat(t, context.Background(), router, chatBody("[up]m", false), nil)
+		defer resp.Body.Close()
+		var completion struct {
+			Choices []struct {
+				Message struct {
+					ToolCalls []struct {
+						ID       string `json:"id"`
+						Type     string `json:"type"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"message"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+			t.Fatal(err)
+		}
+		if len(completion.Choices) != 1 || completion.Choices[0].FinishReason != "tool_calls" {
+			t.Fatalf("Expected one choice finishing with tool_calls, got %+v", completion)
+		}
+		calls := completion.Choices[0].Message.ToolCalls
+		if len(calls) != 2 {
+			t.Fatalf("Expected 2 tool calls, got %+v", calls)
+		}
+		if calls[0].ID != "call_a" || calls[0].Type != "function" || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+			t.Errorf("Expected the first call assembled from its fragments, got %+v", calls[0])
+		}
+		if calls[1].ID != "call_b" || calls[1].Function.Name != "get_time" {
+			t.Errorf("Expected the second call, got %+v", calls[1])
+		}
+	})
+}
+
+func TestRouterUpstreamErrors(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handle = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"unsupported parameter","type":"invalid_request_error"}}`)
+	}
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	_, router := newRouter(t,
+		Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}},
+		Provider{Name: "down", URL: unreachable.URL, Models: []string{"m"}},
+	)
+
+	for _, stream := range []bool{true, false} {
+		t.Run(fmt.Sprintf("RelayedStream=%t", stream), func(t *testing.T) {
+			resp := postChat(t, context.Background(), router, chatBody("[up]m", stream), nil)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected the upstream status 400, got %d", resp.StatusCode)
+			}
+			if !strings.Contains(string(body), "unsupported parameter") {
+				t.Errorf("Expected the upstream error body, got: %s", body)
+			}
+		})
+	}
+
+	t.Run("Unreachable", func(t *testing.T) {
+		resp := postChat(t, context.Background(), router, chatBody("[down]m", true), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("UnknownProvider", func(t *testing.T) {
+		resp := postChat(t, context.Background(), router, chatBody("[nope]m", true), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("MalformedBody", func(t *testing.T) {
+		resp := postChat(t, context.Background(), router, `{"model":`, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestRouterStreamErrorEvents(t *testing.T) {
+	const errorEvent = `{"error":{"message":"model overloaded","type":"server_error"}}`
+	cases := map[string][]string{
+		"MidStream":  {contentChunk("Hel"), errorEvent},
+		"FirstEvent": {errorEvent},
+	}
+	for name, events := range cases {
+		for _, stream := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/Stream=%t", name, stream), func(t *testing.T) {
+				upstream := newFakeUpstream(t, events...)
+				s, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}})
+
+				resp := postChat(t, context.Background(), router, chatBody("[up]m", stream), nil)
+				defer resp.Body.Close()
+				if stream {
+					got := readEvents(t, resp.Body)
+					if resp.StatusCode != http.StatusOK || len(got) != len(events) || got[len(got)-1] != errorEvent {
+						t.Errorf("Expected the error event to end the stream, got %d %v", resp.StatusCode, got)
+					}
+				} else {
+					var body struct {
+						Error map[string]interface{} `json:"error"`
+					}
+					json.NewDecoder(resp.Body).Decode(&body)
+					if resp.StatusCode != http.StatusBadGateway || body.Error["code"] != "upstream_error" || !strings.Contains(body.Error["message"].(string), "model overloaded") {
+						t.Errorf("Expected a 502 with the upstream message, got %d %v", resp.StatusCode, body.Error)
+					}
+				}
+
+				stats := s.statsFor("up")
+				stats.mu.Lock()
+				lastError := stats.lastError
+				stats.mu.Unlock()
+				if !strings.Contains(lastError, "model overloaded") {
+					t.Errorf("Expected the error event to be recorded as a failure, got %q", lastError)
+				}
+			})
+		}
+	}
+}
+
+func TestRouterCancellation(t *testing.T) {
+	chunks := make([]string, 50)
+	for i := range chunks {
+		chunks[i] = contentChunk("x")
+	}
+	upstream := newFakeUpstream(t, chunks...)
+	upstream.delay = 20 * time.Millisecond
+	_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}, ConcurrentLimit: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp := postChat(t, ctx, router, chatBody("[up]m", true), nil)
+	reader := bufio.NewReader(resp.Body)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("Expected the stream to start: %v", err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for upstream.cancelled.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if upstream.cancelled.Load() != 1 {
+		t.Fatal("Expected the upstream request to be cancelled with the client")
+	}
+
+	// The concurrency slot must be released for the next request.
+	upstream.delay = 0
+	done := make(chan int, 1)
+	go func() {
+		resp := postChat(t, context.Background(), router, chatBody("[up]m", false), nil)
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	select {
+	case status := <-done:
+		if status != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the limiter slot to be released after cancellation")
+	}
+}
+
+func TestRouterConcurrencyCap(t *testing.T) {
+	upstream := newFakeUpstream(t, contentChunk("a"), contentChunk("b"))
+	upstream.delay = 30 * time.Millisecond
+	_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}, ConcurrentLimit: 2})
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, 6)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := postChat(t, context.Background(), router, chatBody("[up]m", true), nil)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("Expected queued requests to succeed, got %d", status)
+		}
+	}
+	if max := upstream.maxActive.Load(); max != 2 {
+		t.Errorf("Expected at most 2 concurrent upstream requests, saw %d", max)
+	}
+}
+
+func TestFindProvider(t *testing.T) {
+	s := NewServer(&Config{Port: 8080, Providers: []Provider{
+		{Name: "a", URL: "http://a", Secret: "sk", Models: []string{"m"}},
+		{Name: "ab", URL: "http://ab", Secret: "sk", Models: []string{"m"}},
+	}}, "")
+
+	tests := []struct {
+		model    string
+		provider string
+		actual   string
+	}{
+		{"[a]m", "a", "m"},
+		{"[ab]org/model", "ab", "org/model"},
+		{"[a]", "", "[a]"},
+		{"m", "", "m"},
+		{"[c]m", "", "[c]m"},
+		{"a]m", "", "a]m"},
+	}
+	for _, tt := range tests {
+		provider := s.FindProvider(tt.model)
+		name := ""
+		if provider != nil {
+			name = provider.Name
+		}
+		if name != tt.provider {
+			t.Errorf("FindProvider(%q) = %q, want %q", tt.model, name, tt.provider)
+		}
+		if got := s.GetActualModelName(tt.model); got != tt.actual {
+			t.Errorf("GetActualModelName(%q) = %q, want %q", tt.model, got, tt.actual)
+		}
+	}
+}
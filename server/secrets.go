@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const secretCommandTimeout = 10 * time.Second
+
+// resolveSecret turns a secret reference from the config into its value.
+// Supported forms are:
+//
+//	${ENV:NAME}    the environment variable NAME
+//	file:PATH      the trimmed contents of PATH, "~" expands to the home dir
+//	cmd:COMMAND    the first line printed by COMMAND, run through the shell
+//
+// Any other value is used literally. Errors never include the secret itself.
+func resolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "${ENV:") && strings.HasSuffix(ref, "}"):
+		name := strings.TrimSuffix(strings.TrimPrefix(ref, "${ENV:"), "}")
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+
+	case strings.HasPrefix(ref, "file:"):
+		path, err := expandHome(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "" {
+			return "", fmt.Errorf("secret file %s is empty", path)
+		}
+		return value, nil
+
+	case strings.HasPrefix(ref, "cmd:"):
+		return runSecretCommand(strings.TrimPrefix(ref, "cmd:"))
+	}
+
+	return ref, nil
+}
+
+func runSecretCommand(command string) (string, error) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return "", errors.New("secret command is empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		// Output may hold part of the secret, so only the exit status is reported.
+		return "", fmt.Errorf("secret command %q failed: %w", command, err)
+	}
+
+	value := strings.TrimSpace(string(out))
+	if i := strings.IndexByte(value, '\n'); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	if value == "" {
+		return "", fmt.Errorf("secret command %q printed nothing", command)
+	}
+	return value, nil
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to expand ~: %w", err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// resolveSecrets replaces every provider secret reference with its value.
+// References that fail to resolve are left in place and reported together.
+func (c *Config) resolveSecrets() error {
+	var errs []error
+	for i := range c.Providers {
+		provider := &c.Providers[i]
+		if provider.Secret == "" {
+			continue
+		}
+		value, err := resolveSecret(provider.Secret)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("provider %s: failed to resolve secret: %w", provider.label(i), err))
+			continue
+		}
+		provider.Secret = value
+	}
+	if c.Admin != nil && c.Admin.Token != "" {
+		value, err := resolveSecret(c.Admin.Token)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("admin: failed to resolve token: %w", err))
+		} else {
+			c.Admin.Token = value
+		}
+	}
+	return errors.Join(errs...)
+}
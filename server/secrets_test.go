@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Run("Literal", func(t *testing.T) {
+		value, err := resolveSecret("sk-literal")
+		if err != nil || value != "sk-literal" {
+			t.Errorf("Expected literal secret, got %q, %v", value, err)
+		}
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		t.Setenv("LOCAL_ROUTER_TEST_KEY", "sk-env")
+		value, err := resolveSecret("${ENV:LOCAL_ROUTER_TEST_KEY}")
+		if err != nil || value != "sk-env" {
+			t.Errorf("Expected env secret, got %q, %v", value, err)
+		}
+	})
+
+	t.Run("MissingEnv", func(t *testing.T) {
+		_, err := resolveSecret("${ENV:LOCAL_ROUTER_TEST_MISSING}")
+		if err == nil {
+			t.Error("Expected error for missing env var, got nil")
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key")
+		if err := os.WriteFile(path, []byte("sk-file\n"), 0o600); err != nil {
+			t.Fatalf("Failed to write secret file: %v", err)
+		}
+		value, err := resolveSecret("file:" + path)
+		if err != nil || value != "sk-file" {
+			t.Errorf("Expected file secret, got %q, %v", value, err)
+		}
+	})
+
+	t.Run("Command", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("shell test requires sh")
+		}
+		value, err := resolveSecret("cmd:printf 'sk-cmd\\nextra\\n'")
+		if err != nil || value != "sk-cmd" {
+			t.Errorf("Expected command secret, got %q, %v", value, err)
+		}
+	})
+
+}
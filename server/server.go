@@ -2,66 +2,81 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func (s *Server) loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		GetLogger().Info("ENDPOINT CALLED: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		GetLogger().Debug("endpoint called", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
 		next.ServeHTTP(w, r)
 	}
 }
 
 func (s *Server) logAllRequests(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		GetLogger().Info("REQUEST ATTEMPT: %s %s from %s - User-Agent: %s", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+		GetLogger().Info("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "user_agent", r.UserAgent())
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (s *Server) timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx, cancel := context.WithTimeout(r.Context(), timeout)
-			defer cancel()
-			r = r.WithContext(ctx)
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
 func (s *Server) SetupRoutes() http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/v1/models", s.loggingMiddleware(s.ModelsHandler))
+	modelsTimeout := func(t *TimeoutsConfig) time.Duration { return t.Models }
+	adminTimeout := func(t *TimeoutsConfig) time.Duration { return t.Admin }
+
+	mux.HandleFunc("/v1/models", s.loggingMiddleware(s.withTimeout(modelsTimeout, defaultModelsTimeout, s.ModelsHandler)))
+	mux.HandleFunc("/v1/models/", s.loggingMiddleware(s.withTimeout(modelsTimeout, defaultModelsTimeout, s.ModelHandler)))
+	// Chat completions manage their own deadlines, see completionTimeouts.
 	mux.HandleFunc("/v1/chat/completions", s.loggingMiddleware(s.ForwardRequest))
 
 	// Local Router API endpoints
-	mux.HandleFunc("/local-router/api/config/reload", s.loggingMiddleware(s.ConfigReloadHandler))
-	mux.HandleFunc("/local-router/api/openapi.json", s.loggingMiddleware(s.OpenAPIHandler))
+	mux.HandleFunc("/local-router/api/config/reload", s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.ConfigReloadHandler)))
+	mux.HandleFunc("/local-router/api/status", s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.StatusHandler)))
+	mux.HandleFunc("/local-router/api/status/providers", s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.ProviderStatusHandler)))
+	mux.HandleFunc(statusStreamsPath, s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.StreamsHandler)))
+	mux.HandleFunc(statusStreamsPath+"/", s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.requireAdmin(s.CancelStreamHandler))))
+	mux.HandleFunc(providersAPIPath, s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.requireAdmin(s.ProvidersHandler))))
+	mux.HandleFunc(providersAPIPath+"/", s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.requireAdmin(s.ProvidersHandler))))
+	mux.HandleFunc("/local-router/api/openapi.json", s.loggingMiddleware(s.withTimeout(adminTimeout, defaultAdminTimeout, s.OpenAPIHandler)))
 
-	handler := s.logAllRequests(mux)
-	handler = s.timeoutMiddleware(30 * time.Second)(handler)
-
-	return handler
+	return s.trackRequests(s.logAllRequests(mux))
 }
 
 func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%d", s.config.Port)
-	logger := GetLogger()
-	logger.Info("Starting server on port %d", s.config.Port)
-	logger.Info("Server ready to accept connections")
+	listeners, err := s.openListeners()
+	if err != nil {
+		return err
+	}
+	server := s.newHTTPServer()
 
-	handler := s.SetupRoutes()
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go s.watchConfig(ctx)
+	go s.handleSIGHUP(ctx)
+	go s.runDiscovery(ctx)
+	go s.runHealthChecks(ctx)
+
+	serveErr := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(l net.Listener) { serveErr <- server.Serve(l) }(listener)
 	}
+	GetLogger().Info("server started", "listeners", len(listeners))
 
-	return server.ListenAndServe()
+	select {
+	case err := <-serveErr:
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+	// Restore default signal handling so a second signal stops the
+	// process without waiting for the drain.
+	stop()
+	s.shutdown(server, s.drainTimeout())
+	return nil
 }
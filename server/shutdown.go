@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// forceCloseGrace is how long handlers get to unwind after the drain window
+// closes and their requests are cancelled.
+const forceCloseGrace = 5 * time.Second
+
+// requestStats counts requests over the life of the server.
+type requestStats struct {
+	total     atomic.Int64
+	active    atomic.Int64
+	completed atomic.Int64
+	rejected  atomic.Int64
+}
+
+// ShutdownReport summarizes a shutdown.
+type ShutdownReport struct {
+	Total       int64
+	Completed   int64
+	Rejected    int64
+	Interrupted int64
+	Drained     bool
+}
+
+// trackRequests counts requests and turns new ones away once the server is
+// draining.
+func (s *Server) trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			s.stats.rejected.Add(1)
+			w.Header().Set("Connection", "close")
+			writeOpenAIError(w, http.StatusServiceUnavailable, "server_error", "shutting_down", "server is shutting down")
+			return
+		}
+
+		s.stats.total.Add(1)
+		s.stats.active.Add(1)
+		defer func() {
+			s.stats.active.Add(-1)
+			s.stats.completed.Add(1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) newHTTPServer() *http.Server {
+	return &http.Server{
+		Handler:           s.SetupRoutes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		// No WriteTimeout: it would cut off long streams. Each route bounds
+		// its own work instead.
+		IdleTimeout: 120 * time.Second,
+		BaseContext: func(net.Listener) context.Context { return s.requestCtx },
+	}
+}
+
+func (s *Server) drainTimeout() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return firstPositive(s.config.Timeouts.Drain, defaultDrainTimeout)
+}
+
+// shutdown stops accepting connections and waits up to timeout for in-flight
+// requests to finish. Requests still running after that are cancelled and
+// their connections closed.
+func (s *Server) shutdown(server *http.Server, timeout time.Duration) ShutdownReport {
+	logger := GetLogger()
+	s.draining.Store(true)
+	logger.Info("shutting down, draining in-flight requests", "in_flight", s.stats.active.Load(), "drain_timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	report := ShutdownReport{Drained: true}
+	if err := server.Shutdown(ctx); err != nil {
+		report.Drained = false
+		report.Interrupted = s.stats.active.Load()
+		logger.Warn("drain window closed, interrupting remaining requests", "in_flight", report.Interrupted)
+		s.cancelRequests()
+		server.Close()
+		s.waitIdle(forceCloseGrace)
+	}
+	s.cancelRequests()
+
+	report.Total = s.stats.total.Load()
+	report.Completed = s.stats.completed.Load() - report.Interrupted
+	report.Rejected = s.stats.rejected.Load()
+	logger.Info("server stopped", "requests", report.Total, "completed", report.Completed, "interrupted", report.Interrupted, "rejected", report.Rejected)
+	FlushLogs()
+	return report
+}
+
+// waitIdle waits until no request handlers are running or timeout passes.
+func (s *Server) waitIdle(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for s.stats.active.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
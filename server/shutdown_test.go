@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSlowUpstream streams chunks chunks with delay between them.
+func newSlowUpstream(t *testing.T, chunks int, delay time.Duration) string {
+	done := make(chan struct{})
+	upstream := http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < chunks; i++ {
+			fmt.Fprintf(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%d\"}}]}\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+		}
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go upstream.Serve(listener)
+	t.Cleanup(func() {
+		close(done)
+		upstream.Close()
+	})
+	return "http://" + listener.Addr().String()
+}
+
+func startDrainTestServer(t *testing.T, upstreamURL string) (*Server, *http.Server, string) {
+	s := NewServer(&Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:   "slow",
+			URL:    upstreamURL,
+			Secret: "sk",
+			Models: []string{"m"},
+		}},
+	}, "")
+	server := s.newHTTPServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	return s, server, "http://" + listener.Addr().String()
+}
+
+// startStream sends a streaming completion and returns a channel with the
+// full response body, after waiting for the first chunk to arrive.
+func startStream(t *testing.T, baseURL string) <-chan string {
+	body := `{"model":"[slow]m","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	resp, err := http.Post(baseURL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		t.Fatalf("Expected the stream to start: %v", err)
+	}
+
+	result := make(chan string, 1)
+	go func() {
+		defer resp.Body.Close()
+		rest, _ := io.ReadAll(resp.Body)
+		result <- string(first) + string(rest)
+	}()
+	return result
+}
+
+func TestShutdownDrainsInFlightStreams(t *testing.T) {
+	s, server, baseURL := startDrainTestServer(t, newSlowUpstream(t, 5, 50*time.Millisecond))
+	result := startStream(t, baseURL)
+
+	report := s.shutdown(server, 5*time.Second)
+
+	if !report.Drained || report.Interrupted != 0 {
+		t.Errorf("Expected a clean drain, got %+v", report)
+	}
+	if report.Total != 1 || report.Completed != 1 {
+		t.Errorf("Expected 1 completed request, got %+v", report)
+	}
+	if out := <-result; !strings.Contains(out, `"content":"4"`) {
+		t.Errorf("Expected the stream to finish during the drain, got: %s", out)
+	}
+
+	if _, err := http.Get(baseURL + "/v1/models"); err == nil {
+		t.Error("Expected new connections to be refused after shutdown")
+	}
+}
+
+func TestShutdownInterruptsStreamsAfterDrainWindow(t *testing.T) {
+	s, server, baseURL := startDrainTestServer(t, newSlowUpstream(t, 100, 100*time.Millisecond))
+	result := startStream(t, baseURL)
+
+	start := time.Now()
+	report := s.shutdown(server, 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected shutdown to stop waiting after the drain window, took %s", elapsed)
+	}
+
+	if report.Drained || report.Interrupted != 1 || report.Completed != 0 {
+		t.Errorf("Expected 1 interrupted request, got %+v", report)
+	}
+	if s.stats.active.Load() != 0 {
+		t.Errorf("Expected all handlers to have returned, %d still active", s.stats.active.Load())
+	}
+	if out := <-result; strings.Contains(out, `"content":"99"`) {
+		t.Error("Expected the stream to be cut off")
+	}
+}
+
+func TestDrainingServerRejectsRequests(t *testing.T) {
+	s := NewServer(&Config{Port: 8080}, "")
+	s.draining.Store(true)
+
+	handler := s.SetupRoutes()
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/models", nil)
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+	if s.stats.rejected.Load() != 1 {
+		t.Errorf("Expected 1 rejected request, got %d", s.stats.rejected.Load())
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// requestIDHeader carries the ID under which a completion is listed by
+	// the streams endpoint.
+	requestIDHeader = "X-Local-Router-Request-Id"
+
+	statusStreamsPath = "/local-router/api/status/streams"
+
+	// latencySamples is how many recent upstream latencies are kept per
+	// provider for the reported percentiles.
+	latencySamples = 128
+)
+
+// providerStats tracks what the status endpoint reports about a provider
+// beyond its circuit breaker.
+type providerStats struct {
+	inFlight atomic.Int64
+	queued   atomic.Int64
+
+	mu          sync.Mutex
+	latencies   [latencySamples]time.Duration
+	samples     int
+	next        int
+	lastError   string
+	lastErrorAt time.Time
+	probedAt    time.Time
+	probeOK     bool
+	probeError  string
+}
+
+// recordCall adds the time an upstream took to answer with headers, and its
+// error, if any.
+func (p *providerStats) recordCall(latency time.Duration, errMsg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latencies[p.next] = latency
+	p.next = (p.next + 1) % latencySamples
+	if p.samples < latencySamples {
+		p.samples++
+	}
+	if errMsg != "" {
+		p.lastError = errMsg
+		p.lastErrorAt = time.Now()
+	}
+}
+
+func (p *providerStats) recordProbe(ok bool, errMsg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probedAt = time.Now()
+	p.probeOK = ok
+	p.probeError = errMsg
+	if !ok {
+		p.lastError = errMsg
+		p.lastErrorAt = p.probedAt
+	}
+}
+
+// LatencyStatus holds percentiles of recent upstream latencies, measured to
+// the response headers, in milliseconds.
+type LatencyStatus struct {
+	Samples int   `json:"samples"`
+	P50     int64 `json:"p50_ms"`
+	P90     int64 `json:"p90_ms"`
+	P99     int64 `json:"p99_ms"`
+}
+
+// HealthStatus is the result of the latest health probe.
+type HealthStatus struct {
+	OK        bool      `json:"ok"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (p *providerStats) latency() LatencyStatus {
+	p.mu.Lock()
+	sorted := append([]time.Duration(nil), p.latencies[:p.samples]...)
+	p.mu.Unlock()
+	if len(sorted) == 0 {
+		return LatencyStatus{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(q float64) int64 {
+		return sorted[int(q*float64(len(sorted)-1))].Milliseconds()
+	}
+	return LatencyStatus{Samples: len(sorted), P50: percentile(0.5), P90: percentile(0.9), P99: percentile(0.99)}
+}
+
+// initProviderStats rebuilds the stats map from the current config, keeping
+// the stats of providers that are still configured. Callers must hold s.mu.
+func (s *Server) initProviderStats() {
+	stats := make(map[string]*providerStats)
+	for _, provider := range s.config.Providers {
+		if existing, ok := s.providerStats[provider.Name]; ok {
+			stats[provider.Name] = existing
+		} else {
+			stats[provider.Name] = &providerStats{}
+		}
+	}
+	s.providerStats = stats
+}
+
+func (s *Server) statsFor(providerName string) *providerStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p, ok := s.providerStats[providerName]; ok {
+		return p
+	}
+	// Like breaker, this only guards against a reload that removed the
+	// provider.
+	return &providerStats{}
+}
+
+// activeRequest is a chat completion that is waiting for or reading from an
+// upstream.
+type activeRequest struct {
+	id       string
+	client   string
+	model    string
+	provider string
+	stream   bool
+	started  time.Time
+	watchdog *streamWatchdog
+}
+
+// activeRequests indexes the running completions by ID so that they can be
+// listed and cancelled.
+type activeRequests struct {
+	mu   sync.Mutex
+	byID map[string]*activeRequest
+}
+
+func (a *activeRequests) add(req *activeRequest) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.byID == nil {
+		a.byID = make(map[string]*activeRequest)
+	}
+	a.byID[req.id] = req
+}
+
+func (a *activeRequests) remove(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.byID, id)
+}
+
+func (a *activeRequests) get(id string) *activeRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.byID[id]
+}
+
+// StreamStatus is one running completion in the streams endpoint.
+type StreamStatus struct {
+	ID       string    `json:"id"`
+	Client   string    `json:"client,omitempty"`
+	Model    string    `json:"model"`
+	Provider string    `json:"provider"`
+	Stream   bool      `json:"stream"`
+	Started  time.Time `json:"started"`
+	AgeMs    int64     `json:"age_ms"`
+}
+
+// list returns the running completions, oldest first.
+func (a *activeRequests) list() []StreamStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	streams := make([]StreamStatus, 0, len(a.byID))
+	for _, req := range a.byID {
+		streams = append(streams, StreamStatus{
+			ID:       req.id,
+			Client:   req.client,
+			Model:    req.model,
+			Provider: req.provider,
+			Stream:   req.stream,
+			Started:  req.started,
+			AgeMs:    now.Sub(req.started).Milliseconds(),
+		})
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if !streams[i].Started.Equal(streams[j].Started) {
+			return streams[i].Started.Before(streams[j].Started)
+		}
+		return streams[i].ID < streams[j].ID
+	})
+	return streams
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "req-" + hex.EncodeToString(b[:])
+}
+
+// ProviderStatus is one provider's entry in the status endpoint.
+type ProviderStatus struct {
+	Name            string        `json:"name"`
+	Enabled         bool          `json:"enabled"`
+	Models          []string      `json:"models"`
+	InFlight        int64         `json:"in_flight"`
+	Queued          int64         `json:"queued"`
+	ConcurrentLimit int           `json:"concurrent_limit,omitempty"`
+	Circuit         CircuitStatus `json:"circuit"`
+	Health          *HealthStatus `json:"health,omitempty"`
+	Latency         LatencyStatus `json:"latency"`
+	LastError       string        `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time    `json:"last_error_at,omitempty"`
+}
+
+func (s *Server) providerStatuses() []ProviderStatus {
+	s.mu.RLock()
+	providers := make([]*Provider, 0, len(s.config.Providers))
+	for i := range s.config.Providers {
+		providers = append(providers, &s.config.Providers[i])
+	}
+	s.mu.RUnlock()
+
+	statuses := make([]ProviderStatus, 0, len(providers))
+	for _, provider := range providers {
+		stats := s.statsFor(provider.Name)
+		status := ProviderStatus{
+			Name:            provider.Name,
+			Enabled:         provider.enabled(),
+			Models:          []string{},
+			InFlight:        stats.inFlight.Load(),
+			Queued:          stats.queued.Load(),
+			ConcurrentLimit: provider.ConcurrentLimit,
+			Circuit:         s.breaker(provider.Name).Status(),
+			Latency:         stats.latency(),
+		}
+		for _, model := range s.providerModels(provider) {
+			status.Models = append(status.Models, model.ID)
+		}
+
+		stats.mu.Lock()
+		if !stats.probedAt.IsZero() {
+			status.Health = &HealthStatus{OK: stats.probeOK, CheckedAt: stats.probedAt, Error: stats.probeError}
+		}
+		if stats.lastError != "" {
+			status.LastError = stats.lastError
+			lastErrorAt := stats.lastErrorAt
+			status.LastErrorAt = &lastErrorAt
+		}
+		stats.mu.Unlock()
+
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (s *Server) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeStatusJSON(w, map[string]interface{}{
+		"providers": s.providerStatuses(),
+		"streams":   s.active.list(),
+		"requests": map[string]int64{
+			"total":     s.stats.total.Load(),
+			"active":    s.stats.active.Load(),
+			"completed": s.stats.completed.Load(),
+			"rejected":  s.stats.rejected.Load(),
+		},
+	})
+}
+
+func (s *Server) ProviderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeStatusJSON(w, map[string]interface{}{"providers": s.providerStatuses()})
+}
+
+// StreamsHandler lists running completions.
+func (s *Server) StreamsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeStatusJSON(w, map[string]interface{}{"streams": s.active.list()})
+}
+
+// CancelStreamHandler cancels a running completion with
+// DELETE /local-router/api/status/streams/{id}. It is served behind
+// requireAdmin.
+func (s *Server) CancelStreamHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, statusStreamsPath), "/")
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := s.active.get(id)
+	if req == nil {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "stream_not_found", "No active stream with ID '"+id+"'")
+		return
+	}
+	req.watchdog.Abort(errCancelledByAdmin)
+	Log(ComponentStreaming).Warn("cancelled stream", "request_id", id, "model", req.model, "provider", req.provider, "client", req.client)
+	writeStatusJSON(w, map[string]interface{}{"id": id, "cancelled": true})
+}
+
+func writeStatusJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		GetLogger().Error("failed to encode status response", "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type statusResponse struct {
+	Providers []ProviderStatus `json:"providers"`
+	Streams   []StreamStatus   `json:"streams"`
+}
+
+func getStatus(t *testing.T, router *httptest.Server, path string) statusResponse {
+	resp, err := http.Get(router.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status statusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// waitForStatus polls path until ok accepts the status.
+func waitForStatus(t *testing.T, router *httptest.Server, path string, ok func(statusResponse) bool) statusResponse {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := getStatus(t, router, path)
+		if ok(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for status, last %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProviderStatus(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newFakeUpstream(t)
+	upstream.handle = func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: " + contentChunk("ok") + "\n\ndata: [DONE]\n\n"))
+	}
+	failing := newFakeUpstream(t)
+	failing.handle = func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}
+	_, router := newRouter(t,
+		Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}, ConcurrentLimit: 1},
+		Provider{Name: "bad", URL: failing.URL, Models: []string{"x"}},
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := postChat(t, context.Background(), router, chatBody("[up]m", false), nil)
+			resp.Body.Close()
+		}()
+	}
+	status := waitForStatus(t, router, "/local-router/api/status/providers", func(s statusResponse) bool {
+		return s.Providers[0].InFlight == 1 && s.Providers[0].Queued == 1
+	})
+	if p := status.Providers[0]; p.ConcurrentLimit != 1 || len(p.Models) != 1 || p.Models[0] != "[up]m" || p.Circuit.State != "disabled" {
+		t.Errorf("Expected the limit, models and circuit of up, got %+v", p)
+	}
+	close(release)
+	wg.Wait()
+
+	resp := postChat(t, context.Background(), router, chatBody("[bad]x", false), nil)
+	resp.Body.Close()
+
+	status = getStatus(t, router, "/local-router/api/status")
+	up, bad := status.Providers[0], status.Providers[1]
+	if up.InFlight != 0 || up.Queued != 0 || up.Latency.Samples != 2 || up.LastError != "" {
+		t.Errorf("Expected two finished calls on up, got %+v", up)
+	}
+	if bad.LastError != "500 Internal Server Error" || bad.LastErrorAt == nil || bad.Latency.Samples != 1 {
+		t.Errorf("Expected the failure on bad to be reported, got %+v", bad)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	var stats providerStats
+	for i := 1; i <= latencySamples+100; i++ {
+		stats.recordCall(time.Duration(i)*time.Millisecond, "")
+	}
+	// Only the latest latencySamples calls, 101ms to 228ms, count.
+	if got := stats.latency(); got != (LatencyStatus{Samples: latencySamples, P50: 164, P90: 215, P99: 226}) {
+		t.Errorf("Unexpected percentiles %+v", got)
+	}
+}
+
+func TestCancelStream(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.handle = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: " + contentChunk("Hel") + "\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}
+	s, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}})
+	s.config.Admin = &AdminConfig{Token: "admin-secret"}
+
+	resp := postChat(t, context.Background(), router, chatBody("[up]m", true), http.Header{clientHeader: {"editor"}})
+	defer resp.Body.Close()
+	id := resp.Header.Get(requestIDHeader)
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || !strings.Contains(line, "Hel") {
+		t.Fatalf("Expected the first chunk, got %q, %v", line, err)
+	}
+
+	streams := getStatus(t, router, statusStreamsPath).Streams
+	if len(streams) != 1 || streams[0].ID != id || streams[0].Client != "editor" || streams[0].Model != "[up]m" || !streams[0].Stream {
+		t.Fatalf("Expected the running stream %q to be listed, got %+v", id, streams)
+	}
+
+	cancel := func(id, token string) int {
+		resp, _ := adminRequest(t, router, http.MethodDelete, statusStreamsPath+"/"+id, token, "")
+		return resp.StatusCode
+	}
+	if code := cancel(id, ""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected cancelling without the admin token to be rejected, got %d", code)
+	}
+	if code := cancel(id, "admin-secret"); code != http.StatusOK {
+		t.Fatalf("Expected the stream to be cancelled, got %d", code)
+	}
+
+	events := readEvents(t, reader)
+	if len(events) == 0 || !strings.Contains(events[len(events)-1], `"code":"cancelled"`) {
+		t.Errorf("Expected the stream to end with a cancelled error, got %v", events)
+	}
+	waitForStatus(t, router, statusStreamsPath, func(s statusResponse) bool { return len(s.Streams) == 0 })
+	if code := cancel(id, "admin-secret"); code != http.StatusNotFound {
+		t.Errorf("Expected a finished stream to be unknown, got %d", code)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxSSELine bounds one SSE line; long tool call arguments can arrive in
+	// a single chunk.
+	maxSSELine = 4 << 20
+	// maxErrorBody bounds an upstream error body relayed to the client.
+	maxErrorBody = 1 << 20
+)
+
+var (
+	// errUpstreamErrorEvent is reported for an error event in a stream that
+	// started with status 200.
+	errUpstreamErrorEvent = errors.New("upstream sent an error event")
+	errEmptyStream        = errors.New("upstream returned no completion")
+)
+
+// errorEventMessage returns the message of an error event's error field,
+// which providers send as an object or as a plain string.
+func errorEventMessage(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if message := getString(v, "message"); message != "" {
+			return message
+		}
+	case string:
+		if v != "" {
+			return v
+		}
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// isUpstreamError reports whether an upstream response should be relayed
+// verbatim instead of parsed as an SSE stream: an error status, or a JSON
+// body from a provider that ignored stream: true.
+func isUpstreamError(statusCode int, header http.Header) bool {
+	if statusCode >= 400 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// relayUpstreamError copies an upstream error response to the client as is.
+func relayUpstreamError(w http.ResponseWriter, body io.Reader, statusCode int, modelName string) {
+	data, err := io.ReadAll(io.LimitReader(body, maxErrorBody))
+	if err != nil {
+		Log(ComponentStreaming).Warn("failed to read upstream error body", "model", modelName, "error", err)
+	}
+	Log(ComponentStreaming).Warn("upstream returned an error", "model", modelName, "status", statusCode, "body", string(data))
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// streamAccumulator assembles streamed chunks into the complete response
+// sent to non-streaming clients.
+type streamAccumulator struct {
+	id      string
+	created int64
+	choices map[int]*accumulatedChoice
+	usage   map[string]interface{}
+	extra   map[string]interface{}
+}
+
+type accumulatedChoice struct {
+	role         string
+	content      strings.Builder
+	toolCalls    map[int]map[string]interface{}
+	finishReason string
+}
+
+func newStreamAccumulator() *streamAccumulator {
+	return &streamAccumulator{choices: make(map[int]*accumulatedChoice)}
+}
+
+func (a *streamAccumulator) add(chunk *ChatCompletionResponse) {
+	if a.id == "" {
+		a.id = chunk.ID
+		a.created = chunk.Created
+		a.extra = chunk.Extra
+	}
+	if chunk.Usage != nil {
+		a.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		acc, ok := a.choices[choice.Index]
+		if !ok {
+			acc = &accumulatedChoice{toolCalls: make(map[int]map[string]interface{})}
+			a.choices[choice.Index] = acc
+		}
+		if choice.FinishReason != "" {
+			acc.finishReason = choice.FinishReason
+		}
+		if choice.Delta == nil {
+			continue
+		}
+		if choice.Delta.Role != "" {
+			acc.role = choice.Delta.Role
+		}
+		acc.content.WriteString(choice.Delta.Content)
+		for _, fragment := range choice.Delta.ToolCalls {
+			acc.addToolCall(fragment)
+		}
+	}
+}
+
+func (c *accumulatedChoice) addToolCall(fragment ToolCall) {
+	call, ok := c.toolCalls[fragment.Index]
+	if !ok {
+		call = map[string]interface{}{
+			"type":     "function",
+			"function": map[string]interface{}{"name": "", "arguments": ""},
+		}
+		c.toolCalls[fragment.Index] = call
+	}
+	if fragment.ID != "" {
+		call["id"] = fragment.ID
+	}
+	if fragment.Type != "" {
+		call["type"] = fragment.Type
+	}
+	function := call["function"].(map[string]interface{})
+	if name := getString(fragment.Function, "name"); name != "" {
+		function["name"] = name
+	}
+	function["arguments"] = getString(function, "arguments") + getString(fragment.Function, "arguments")
+}
+
+// response returns the assembled chat.completion, labelled with modelName.
+func (a *streamAccumulator) response(modelName string) map[string]interface{} {
+	indexes := make([]int, 0, len(a.choices))
+	for index := range a.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	choices := make([]ChatCompletionChoice, 0, len(indexes))
+	for _, index := range indexes {
+		acc := a.choices[index]
+		message := &ChatMessage{Role: acc.role, Content: acc.content.String()}
+		if message.Role == "" {
+			message.Role = "assistant"
+		}
+		if len(acc.toolCalls) > 0 {
+			callIndexes := make([]int, 0, len(acc.toolCalls))
+			for i := range acc.toolCalls {
+				callIndexes = append(callIndexes, i)
+			}
+			sort.Ints(callIndexes)
+			calls := make([]interface{}, 0, len(callIndexes))
+			for _, i := range callIndexes {
+				calls = append(calls, acc.toolCalls[i])
+			}
+			message.Extra = map[string]interface{}{"tool_calls": calls}
+		}
+		choices = append(choices, ChatCompletionChoice{Index: index, Message: message, FinishReason: acc.finishReason})
+	}
+
+	response := ChatCompletionResponse{
+		ID:      a.id,
+		Object:  "chat.completion",
+		Created: a.created,
+		Model:   modelName,
+		Choices: choices,
+		Usage:   a.usage,
+		Extra:   a.extra,
+	}
+	return response.ToMap()
+}
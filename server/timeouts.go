@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultModelsTimeout     = 10 * time.Second
+	defaultAdminTimeout      = 30 * time.Second
+	defaultRequestTimeout    = 10 * time.Minute
+	defaultFirstTokenTimeout = 3 * time.Minute
+	defaultIdleStreamTimeout = 2 * time.Minute
+	defaultDrainTimeout      = 30 * time.Second
+)
+
+// TimeoutsConfig sets route timeouts and the defaults for chat completions.
+// Streaming completions have no total deadline; they are bounded by the time
+// to the first token and by the idle time between chunks instead.
+type TimeoutsConfig struct {
+	Models     time.Duration `yaml:"models"`
+	Admin      time.Duration `yaml:"admin"`
+	Request    time.Duration `yaml:"request"`
+	FirstToken time.Duration `yaml:"firstToken"`
+	IdleStream time.Duration `yaml:"idleStream"`
+	// Drain is how long in-flight requests may run after a shutdown signal.
+	Drain time.Duration `yaml:"drain"`
+}
+
+func (t *TimeoutsConfig) validate() []string {
+	if t.Models < 0 || t.Admin < 0 || t.Request < 0 || t.FirstToken < 0 || t.IdleStream < 0 || t.Drain < 0 {
+		return []string{"durations cannot be negative"}
+	}
+	return nil
+}
+
+func (t *TimeoutsConfig) merge(other *TimeoutsConfig) {
+	if other.Models != 0 {
+		t.Models = other.Models
+	}
+	if other.Admin != 0 {
+		t.Admin = other.Admin
+	}
+	if other.Request != 0 {
+		t.Request = other.Request
+	}
+	if other.FirstToken != 0 {
+		t.FirstToken = other.FirstToken
+	}
+	if other.IdleStream != 0 {
+		t.IdleStream = other.IdleStream
+	}
+	if other.Drain != 0 {
+		t.Drain = other.Drain
+	}
+}
+
+func firstPositive(values ...time.Duration) time.Duration {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// completionTimeouts are the limits applied to one chat completion.
+type completionTimeouts struct {
+	Request    time.Duration
+	FirstToken time.Duration
+	IdleStream time.Duration
+}
+
+// completionTimeouts resolves the limits for a provider: provider settings
+// win over the global timeouts section, which wins over the defaults.
+func (s *Server) completionTimeouts(provider *Provider) completionTimeouts {
+	s.mu.RLock()
+	global := s.config.Timeouts
+	s.mu.RUnlock()
+
+	return completionTimeouts{
+		Request:    firstPositive(provider.RequestTimeout, global.Request, defaultRequestTimeout),
+		FirstToken: firstPositive(provider.FirstTokenTimeout, global.FirstToken, defaultFirstTokenTimeout),
+		IdleStream: firstPositive(provider.IdleStreamTimeout, global.IdleStream, defaultIdleStreamTimeout),
+	}
+}
+
+// withTimeout bounds a handler by the route timeout picked from the current
+// config, falling back to def.
+func (s *Server) withTimeout(pick func(*TimeoutsConfig) time.Duration, def time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		timeout := firstPositive(pick(&s.config.Timeouts), def)
+		s.mu.RUnlock()
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+var (
+	errFirstTokenTimeout = errors.New("no response from upstream before first token timeout")
+	errIdleStream        = errors.New("upstream stream idle timeout")
+	errCancelledByAdmin  = errors.New("request cancelled by an administrator")
+)
+
+// streamWatchdog cancels an upstream exchange that produces no first token
+// within firstToken, or that goes silent for idle once streaming.
+type streamWatchdog struct {
+	mu         sync.Mutex
+	timer      *time.Timer
+	firstToken time.Duration
+	idle       time.Duration
+	started    bool
+	reason     error
+	cancel     context.CancelFunc
+}
+
+func newStreamWatchdog(firstToken, idle time.Duration, cancel context.CancelFunc) *streamWatchdog {
+	w := &streamWatchdog{firstToken: firstToken, idle: idle, cancel: cancel}
+	if d := firstPositive(firstToken, idle); d > 0 {
+		w.timer = time.AfterFunc(d, w.expire)
+	}
+	return w
+}
+
+func (w *streamWatchdog) expire() {
+	w.mu.Lock()
+	if w.started || w.firstToken <= 0 {
+		w.reason = errIdleStream
+	} else {
+		w.reason = errFirstTokenTimeout
+	}
+	w.mu.Unlock()
+	w.cancel()
+}
+
+// FirstToken switches the watchdog from the first-token limit to the idle limit.
+func (w *streamWatchdog) FirstToken() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started || w.reason != nil {
+		return
+	}
+	w.started = true
+	w.reset()
+}
+
+// activity restarts the idle timer once streaming has begun.
+func (w *streamWatchdog) activity() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started && w.reason == nil {
+		w.reset()
+	}
+}
+
+func (w *streamWatchdog) reset() {
+	if w.timer == nil {
+		if w.idle > 0 {
+			w.timer = time.AfterFunc(w.idle, w.expire)
+		}
+		return
+	}
+	if w.idle > 0 {
+		w.timer.Reset(w.idle)
+	} else {
+		w.timer.Stop()
+	}
+}
+
+// Abort cancels the upstream exchange with reason, unless the watchdog has
+// already fired.
+func (w *streamWatchdog) Abort(reason error) {
+	w.mu.Lock()
+	if w.reason == nil {
+		w.reason = reason
+	}
+	w.mu.Unlock()
+	w.cancel()
+}
+
+// Err returns why the watchdog fired, or nil.
+func (w *streamWatchdog) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reason
+}
+
+func (w *streamWatchdog) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// wrap returns body with reads feeding the idle timer and failures caused
+// by the watchdog reported as its reason.
+func (w *streamWatchdog) wrap(body io.ReadCloser) io.ReadCloser {
+	return &watchedBody{ReadCloser: body, watchdog: w}
+}
+
+type watchedBody struct {
+	io.ReadCloser
+	watchdog *streamWatchdog
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.watchdog.activity()
+	}
+	if err != nil && err != io.EOF {
+		if reason := b.watchdog.Err(); reason != nil {
+			return n, reason
+		}
+	}
+	return n, err
+}
+
+// FirstToken lets the stream handler tell the watchdog that data has started.
+func (b *watchedBody) FirstToken() {
+	b.watchdog.FirstToken()
+}
+
+// timeoutErrorCode maps a stream failure to an error code for the client.
+func timeoutErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errFirstTokenTimeout):
+		return "first_token_timeout"
+	case errors.Is(err, errIdleStream):
+		return "stream_idle_timeout"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "request_timeout"
+	case errors.Is(err, errCancelledByAdmin):
+		return "cancelled"
+	default:
+		return "stream_error"
+	}
+}
+
+// streamErrorStatus is the HTTP status for a stream failure reported before
+// the response has started.
+func streamErrorStatus(err error) int {
+	switch timeoutErrorCode(err) {
+	case "stream_error":
+		return http.StatusBadGateway
+	case "cancelled":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusGatewayTimeout
+	}
+}
+
+// writeStreamError ends an SSE stream that has already started with an
+// OpenAI-style error event.
+func writeStreamError(w http.ResponseWriter, err error) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": err.Error(),
+			"type":    "server_error",
+			"code":    timeoutErrorCode(err),
+		},
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
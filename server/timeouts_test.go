@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamTimeouts(t *testing.T) {
+	done := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait := func(d time.Duration) {
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			case <-time.After(d):
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch r.Header.Get("X-Test-Mode") {
+		case "slow-start":
+			wait(5 * time.Second)
+		case "stall":
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"part\"}}]}\n\n")
+			w.(http.Flusher).Flush()
+			wait(5 * time.Second)
+		case "keepalive":
+			// Comments keep the connection busy but are not tokens.
+			for i := 0; i < 20; i++ {
+				fmt.Fprint(w, ": processing\n\n")
+				w.(http.Flusher).Flush()
+				wait(20 * time.Millisecond)
+			}
+		default:
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%d\"}}]}\n\n", i)
+				w.(http.Flusher).Flush()
+				wait(30 * time.Millisecond)
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		}
+	}))
+	defer upstream.Close()
+	defer close(done)
+
+	config := &Config{
+		Port: 8080,
+		Providers: []Provider{{
+			Name:              "p",
+			URL:               upstream.URL,
+			Secret:            "sk",
+			Models:            []string{"m"},
+			FirstTokenTimeout: 100 * time.Millisecond,
+			IdleStreamTimeout: 100 * time.Millisecond,
+			CircuitBreaker:    &CircuitBreakerConfig{Disabled: true},
+		}},
+	}
+	s := NewServer(config, "")
+
+	send := func(mode string) *httptest.ResponseRecorder {
+		body := `{"model":"[p]m","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("X-Test-Mode", mode)
+		rec := httptest.NewRecorder()
+		s.ForwardRequest(rec, req)
+		return rec
+	}
+
+	t.Run("FirstTokenTimeout", func(t *testing.T) {
+		rec := send("slow-start")
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("Expected status 504, got %d", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "first_token_timeout") {
+			t.Errorf("Expected first_token_timeout error, got: %s", rec.Body.String())
+		}
+	})
+
+	t.Run("KeepaliveCommentsDoNotCountAsTokens", func(t *testing.T) {
+		rec := send("keepalive")
+		if !strings.Contains(rec.Body.String(), "first_token_timeout") {
+			t.Errorf("Expected first_token_timeout error event, got: %s", rec.Body.String())
+		}
+	})
+
+	t.Run("IdleStreamSendsErrorEvent", func(t *testing.T) {
+		rec := send("stall")
+		out := rec.Body.String()
+		if !strings.Contains(out, `"content":"part"`) {
+			t.Errorf("Expected the first chunk to be delivered, got: %s", out)
+		}
+		if !strings.Contains(out, `data: {"error":`) || !strings.Contains(out, "stream_idle_timeout") {
+			t.Errorf("Expected an SSE error event, got: %s", out)
+		}
+	})
+
+	t.Run("SlowButSteadyStreamCompletes", func(t *testing.T) {
+		rec := send("steady")
+		out := rec.Body.String()
+		if strings.Contains(out, `"error"`) || !strings.Contains(out, `"content":"2"`) {
+			t.Errorf("Expected a complete stream, got: %s", out)
+		}
+	})
+}
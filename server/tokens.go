@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	TokenizerBPEApprox = "bpe-approx"
+	TokenizerChars     = "chars"
+)
+
+// Tokenizer counts the tokens of a text for a family of models. Counts are
+// used for usage estimates and context window checks, so an approximation
+// is fine.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenizerFunc adapts a function to the Tokenizer interface.
+type TokenizerFunc func(text string) int
+
+func (f TokenizerFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+var tokenizers = struct {
+	sync.RWMutex
+	byName map[string]Tokenizer
+}{byName: map[string]Tokenizer{
+	TokenizerBPEApprox: approxBPETokenizer{},
+	TokenizerChars:     TokenizerFunc(func(text string) int { return (utf8.RuneCountInString(text) + 3) / 4 }),
+}}
+
+// RegisterTokenizer makes a tokenizer available to modelInfo under name,
+// replacing any tokenizer of the same name.
+func RegisterTokenizer(name string, t Tokenizer) {
+	tokenizers.Lock()
+	defer tokenizers.Unlock()
+	tokenizers.byName[name] = t
+}
+
+func lookupTokenizer(name string) (Tokenizer, bool) {
+	tokenizers.RLock()
+	defer tokenizers.RUnlock()
+	t, ok := tokenizers.byName[name]
+	return t, ok
+}
+
+func tokenizerNames() []string {
+	tokenizers.RLock()
+	defer tokenizers.RUnlock()
+	return sortedKeys(tokenizers.byName)
+}
+
+// tokenizerFor returns the tokenizer named in the model info, or the BPE
+// approximation for models without one.
+func tokenizerFor(info *ModelInfo) Tokenizer {
+	if info != nil && info.Tokenizer != "" {
+		if t, ok := lookupTokenizer(info.Tokenizer); ok {
+			return t
+		}
+	}
+	return approxBPETokenizer{}
+}
+
+// approxBPETokenizer approximates byte-pair encodings such as cl100k without
+// their vocabularies: common words are one token, long words split every
+// six letters, digits group by three, and CJK characters and punctuation
+// count one each.
+type approxBPETokenizer struct{}
+
+func (approxBPETokenizer) CountTokens(text string) int {
+	tokens := 0
+	letters, digits := 0, 0
+	flush := func() {
+		if letters > 0 {
+			tokens += (letters + 5) / 6
+		}
+		if digits > 0 {
+			tokens += (digits + 2) / 3
+		}
+		letters, digits = 0, 0
+	}
+
+	newlines := false
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flush()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			if digits > 0 {
+				flush()
+			}
+			letters++
+		case unicode.IsDigit(r):
+			if letters > 0 {
+				flush()
+			}
+			digits++
+		case r == '\n' || r == '\r':
+			flush()
+			// A run of line breaks is a single token.
+			if !newlines {
+				tokens++
+			}
+			newlines = true
+			continue
+		case unicode.IsSpace(r):
+			// A space merges into the word that follows it.
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+		newlines = false
+	}
+	flush()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// countPromptTokens counts a conversation the way OpenAI bills it: a few
+// tokens of framing per message plus three that prime the reply.
+func countPromptTokens(t Tokenizer, messages []ChatMessage) int {
+	tokens := 3
+	for i := range messages {
+		tokens += 3 + t.CountTokens(messages[i].Role) + t.CountTokens(messages[i].Text())
+		if name := getString(messages[i].Extra, "name"); name != "" {
+			tokens += 1 + t.CountTokens(name)
+		}
+		if calls := getSlice(messages[i].Extra, "tool_calls"); len(calls) > 0 {
+			if data, err := json.Marshal(calls); err == nil {
+				tokens += t.CountTokens(string(data))
+			}
+		}
+	}
+	return tokens
+}
+
+func (p *Provider) includeUsage() bool {
+	return p.IncludeUsage != nil && *p.IncludeUsage
+}
+
+// usageEstimator fills in the usage of a completion when the provider does
+// not report it.
+type usageEstimator struct {
+	tokenizer    Tokenizer
+	promptTokens int
+}
+
+func newUsageEstimator(info *ModelInfo, messages []ChatMessage) *usageEstimator {
+	t := tokenizerFor(info)
+	return &usageEstimator{tokenizer: t, promptTokens: countPromptTokens(t, messages)}
+}
+
+// usage returns an OpenAI usage block for the completion text, flagged as
+// estimated.
+func (e *usageEstimator) usage(completion string) map[string]interface{} {
+	completionTokens := e.tokenizer.CountTokens(completion)
+	return map[string]interface{}{
+		"prompt_tokens":     e.promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      e.promptTokens + completionTokens,
+		"estimated":         true,
+	}
+}
+
+// usageTokens reads a token count from a usage block, which holds float64
+// values when parsed from JSON and ints when estimated.
+func usageTokens(usage map[string]interface{}, key string) int {
+	n, _ := toFloat(usage[key])
+	return int(n)
+}
+
+// completionText returns the generated text of every choice, including tool
+// call names and arguments, for usage estimates.
+func (a *streamAccumulator) completionText() string {
+	indexes := make([]int, 0, len(a.choices))
+	for index := range a.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var text []byte
+	for _, index := range indexes {
+		acc := a.choices[index]
+		text = append(text, acc.content.String()...)
+		for _, call := range acc.toolCalls {
+			function, _ := call["function"].(map[string]interface{})
+			text = append(text, getString(function, "name")...)
+			text = append(text, getString(function, "arguments")...)
+		}
+	}
+	return string(text)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestApproxBPETokenizer(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"Hello, world!", 4},
+		{"internationalization", 4},
+		{"1234567", 3},
+		{"你好世界", 4},
+		{"a\n\n\nb", 3},
+		{"GPT4o", 3},
+	}
+	for _, c := range cases {
+		if got := (approxBPETokenizer{}).CountTokens(c.text); got != c.want {
+			t.Errorf("CountTokens(%q) = %d, want %d", c.text, got, c.want)
+		}
+	}
+}
+
+func TestCountPromptTokens(t *testing.T) {
+	RegisterTokenizer("test-words", TokenizerFunc(func(text string) int { return len(strings.Fields(text)) }))
+	t.Cleanup(func() {
+		tokenizers.Lock()
+		delete(tokenizers.byName, "test-words")
+		tokenizers.Unlock()
+	})
+
+	messages := []ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "one two three", Extra: map[string]interface{}{"name": "bob"}},
+	}
+	// 3 to prime the reply, 3 per message, one per role and the words.
+	if got, want := countPromptTokens(tokenizerFor(&ModelInfo{Tokenizer: "test-words"}), messages), 3+(3+1+2)+(3+1+3)+(1+1); got != want {
+		t.Errorf("Expected %d prompt tokens, got %d", want, got)
+	}
+
+	info := &ModelInfo{Tokenizer: "tiktoken"}
+	if problems := info.validate(); len(problems) != 1 || !strings.Contains(problems[0], `unknown tokenizer "tiktoken"`) {
+		t.Errorf("Expected an unknown tokenizer to be rejected, got %v", problems)
+	}
+}
+
+func TestUsageAccounting(t *testing.T) {
+	t.Run("EstimatedWhenMissing", func(t *testing.T) {
+		upstream := newFakeUpstream(t, contentChunk("Hello there"))
+		_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}})
+
+		resp := postChat(t, context.Background(), router, chatBody("[up]m", false), nil)
+		defer resp.Body.Close()
+		var completion struct {
+			Usage map[string]interface{} `json:"usage"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+			t.Fatal(err)
+		}
+		if completion.Usage["estimated"] != true || completion.Usage["completion_tokens"] != 2.0 || completion.Usage["prompt_tokens"] != 8.0 {
+			t.Errorf("Expected an estimated usage block, got %v", completion.Usage)
+		}
+
+		_, forwarded := upstream.received()
+		if forwarded["stream_options"] != nil {
+			t.Errorf("Expected include_usage not to be requested by default, got %v", forwarded["stream_options"])
+		}
+	})
+
+	t.Run("StreamingClientsGetUsage", func(t *testing.T) {
+		upstream := newFakeUpstream(t, contentChunk("Hi"))
+		_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}})
+
+		resp := postChat(t, context.Background(), router, chatBody("[up]m", true), nil)
+		defer resp.Body.Close()
+		events := readEvents(t, resp.Body)
+		if len(events) != 3 || !strings.Contains(events[1], `"choices":[]`) || !strings.Contains(events[1], `"estimated":true`) {
+			t.Errorf("Expected an estimated usage chunk before [DONE], got %v", events)
+		}
+	})
+
+	t.Run("UpstreamUsageIsKept", func(t *testing.T) {
+		upstream := newFakeUpstream(t, contentChunk("Hi"),
+			`{"id":"chatcmpl-1","choices":[],"usage":{"prompt_tokens":11,"completion_tokens":1,"total_tokens":12}}`)
+		_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}})
+
+		resp := postChat(t, context.Background(), router, chatBody("[up]m", true), nil)
+		defer resp.Body.Close()
+		events := readEvents(t, resp.Body)
+		if len(events) != 3 || !strings.Contains(events[1], `"prompt_tokens":11`) || strings.Contains(events[1], "estimated") {
+			t.Errorf("Expected the upstream usage chunk only, got %v", events)
+		}
+	})
+
+	t.Run("IncludeUsage", func(t *testing.T) {
+		body := `{"model":"[up]m","stream":true,"stream_options":{"include_obfuscation":false},"messages":[{"role":"user","content":"hi"}]}`
+		for _, on := range []bool{false, true} {
+			upstream := newFakeUpstream(t, contentChunk("Hi"))
+			include := on
+			_, router := newRouter(t, Provider{Name: "up", URL: upstream.URL, Models: []string{"m"}, IncludeUsage: &include})
+
+			resp := postChat(t, context.Background(), router, body, http.Header{})
+			resp.Body.Close()
+			_, forwarded := upstream.received()
+			options := getMap(forwarded, "stream_options")
+			if requested := options["include_usage"] == true; requested != on || options["include_obfuscation"] != false {
+				t.Errorf("includeUsage %t: expected the client stream_options to be kept, got %v", on, forwarded["stream_options"])
+			}
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TransformRules rewrite the top-level fields of a request before it is
+// forwarded, to smooth over what a provider or model accepts. They run in
+// the order rename, drop, defaults, overrides, clamp.
+type TransformRules struct {
+	// Defaults are set when the client did not send the field.
+	Defaults map[string]interface{} `yaml:"defaults"`
+	// Overrides are always set, replacing what the client sent.
+	Overrides map[string]interface{} `yaml:"overrides"`
+	Drop      []string               `yaml:"drop"`
+	// Rename moves a field to a new name unless the new name is already set.
+	Rename map[string]string     `yaml:"rename"`
+	Clamp  map[string]ClampRange `yaml:"clamp"`
+}
+
+// ClampRange bounds a numeric field. Either end may be left open.
+type ClampRange struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+// reservedFields are handled by the router and cannot be transformed.
+var reservedFields = map[string]bool{"model": true, "messages": true, "stream": true}
+
+func (t *TransformRules) validate() []string {
+	var problems []string
+	check := func(rule, field string) {
+		if reservedFields[field] {
+			problems = append(problems, fmt.Sprintf("%s: field %q cannot be transformed", rule, field))
+		}
+	}
+	for field := range t.Defaults {
+		check("defaults", field)
+	}
+	for field := range t.Overrides {
+		check("overrides", field)
+	}
+	for _, field := range t.Drop {
+		check("drop", field)
+	}
+	for from, to := range t.Rename {
+		check("rename", from)
+		check("rename", to)
+		if to == "" {
+			problems = append(problems, fmt.Sprintf("rename: %s has no target", from))
+		}
+	}
+	for field, r := range t.Clamp {
+		check("clamp", field)
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			problems = append(problems, fmt.Sprintf("clamp: %s min is greater than max", field))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// lookupModelTransform returns the rules for a model. Exact keys win over
+// glob keys; glob keys are tried in sorted order.
+func (p *Provider) lookupModelTransform(model string) *TransformRules {
+	if rules, ok := p.ModelTransforms[model]; ok {
+		return &rules
+	}
+
+	patterns := make([]string, 0, len(p.ModelTransforms))
+	for pattern := range p.ModelTransforms {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matchGlob(pattern, model) {
+			rules := p.ModelTransforms[pattern]
+			return &rules
+		}
+	}
+	return nil
+}
+
+// transformRequest applies the provider rules and then the model rules to
+// the extra fields of a request.
+func (p *Provider) transformRequest(model string, extra map[string]interface{}) {
+	if p.Transform != nil {
+		p.Transform.apply(extra, p.Name, "provider "+p.Name)
+	}
+	if rules := p.lookupModelTransform(model); rules != nil {
+		rules.apply(extra, p.Name, "model "+model)
+	}
+}
+
+func (t *TransformRules) apply(extra map[string]interface{}, provider, scope string) {
+	logger := Log(ComponentRouting)
+	logRewrite := func(field, action string, args ...interface{}) {
+		logger.Debug("transformed request", append([]interface{}{"provider", provider, "rules", scope, "field", field, "action", action}, args...)...)
+	}
+
+	for _, from := range sortedKeys(t.Rename) {
+		to := t.Rename[from]
+		value, ok := extra[from]
+		if !ok {
+			continue
+		}
+		delete(extra, from)
+		if _, exists := extra[to]; exists {
+			logRewrite(from, "drop", "reason", to+" already set")
+			continue
+		}
+		extra[to] = value
+		logRewrite(from, "rename", "to", to)
+	}
+
+	for _, field := range t.Drop {
+		if value, ok := extra[field]; ok {
+			delete(extra, field)
+			logRewrite(field, "drop", "old", value)
+		}
+	}
+
+	for _, field := range sortedKeys(t.Defaults) {
+		if _, ok := extra[field]; !ok {
+			extra[field] = t.Defaults[field]
+			logRewrite(field, "default", "new", t.Defaults[field])
+		}
+	}
+
+	for _, field := range sortedKeys(t.Overrides) {
+		old, existed := extra[field]
+		extra[field] = t.Overrides[field]
+		if existed {
+			logRewrite(field, "override", "old", old, "new", t.Overrides[field])
+		} else {
+			logRewrite(field, "override", "new", t.Overrides[field])
+		}
+	}
+
+	for _, field := range sortedKeys(t.Clamp) {
+		value, ok := extra[field]
+		if !ok {
+			continue
+		}
+		number, ok := toFloat(value)
+		if !ok {
+			logRewrite(field, "clamp", "reason", "not a number", "old", value)
+			continue
+		}
+		r := t.Clamp[field]
+		clamped := number
+		if r.Min != nil && clamped < *r.Min {
+			clamped = *r.Min
+		}
+		if r.Max != nil && clamped > *r.Max {
+			clamped = *r.Max
+		}
+		if clamped != number {
+			extra[field] = clamped
+			logRewrite(field, "clamp", "old", number, "new", clamped)
+		}
+	}
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
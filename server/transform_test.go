@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransformRules(t *testing.T) {
+	defer InitLogger(LogOptions{Level: slog.LevelInfo})
+	var buf bytes.Buffer
+	InitLogger(LogOptions{Level: slog.LevelDebug, Output: &buf})
+
+	maxTokens, minTemp, maxTemp := 4096.0, 0.0, 1.0
+	provider := &Provider{
+		Name: "zhipu",
+		Transform: &TransformRules{
+			Drop:     []string{"logprobs"},
+			Rename:   map[string]string{"max_completion_tokens": "max_tokens"},
+			Defaults: map[string]interface{}{"top_p": 0.9},
+			Clamp: map[string]ClampRange{
+				"max_tokens":  {Max: &maxTokens},
+				"temperature": {Min: &minTemp, Max: &maxTemp},
+			},
+		},
+		ModelTransforms: map[string]TransformRules{
+			"glm-*":   {Overrides: map[string]interface{}{"enable_thinking": false}},
+			"glm-4.6": {Defaults: map[string]interface{}{"top_p": 0.5}},
+		},
+	}
+
+	extra := map[string]interface{}{
+		"logprobs":              true,
+		"max_completion_tokens": 100000.0,
+		"temperature":           1.5,
+		"enable_thinking":       true,
+	}
+	provider.transformRequest("glm-4.5", extra)
+
+	if _, ok := extra["logprobs"]; ok {
+		t.Error("Expected logprobs to be dropped")
+	}
+	if _, ok := extra["max_completion_tokens"]; ok || extra["max_tokens"] != 4096.0 {
+		t.Errorf("Expected max_completion_tokens renamed and clamped, got %v", extra)
+	}
+	if extra["temperature"] != 1.0 {
+		t.Errorf("Expected temperature clamped to 1, got %v", extra["temperature"])
+	}
+	if extra["top_p"] != 0.9 {
+		t.Errorf("Expected the provider default, got %v", extra["top_p"])
+	}
+	if extra["enable_thinking"] != false {
+		t.Errorf("Expected the glob model override, got %v", extra["enable_thinking"])
+	}
+
+	for _, want := range []string{"field=logprobs action=drop", "field=max_completion_tokens action=rename", "field=temperature action=clamp", "field=enable_thinking action=override"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected a debug log with %q, got:\n%s", want, buf.String())
+		}
+	}
+
+	t.Run("ExactModelKeyWins", func(t *testing.T) {
+		extra := map[string]interface{}{}
+		provider.transformRequest("glm-4.6", extra)
+		if extra["top_p"] != 0.9 {
+			t.Errorf("Expected the provider default to apply first, got %v", extra["top_p"])
+		}
+		if _, ok := extra["enable_thinking"]; ok {
+			t.Error("Expected the exact key to be used instead of the glob")
+		}
+	})
+
+	t.Run("DefaultsKeepClientValues", func(t *testing.T) {
+		extra := map[string]interface{}{"top_p": 0.1, "max_tokens": 10.0, "max_completion_tokens": 20.0}
+		provider.transformRequest("other", extra)
+		if extra["top_p"] != 0.1 {
+			t.Errorf("Expected the client value to be kept, got %v", extra["top_p"])
+		}
+		if extra["max_tokens"] != 10.0 {
+			t.Errorf("Expected rename not to overwrite an existing field, got %v", extra["max_tokens"])
+		}
+	})
+}
+
+func TestTransformRulesValidation(t *testing.T) {
+	low, high := 10.0, 1.0
+	rules := TransformRules{
+		Drop:   []string{"messages"},
+		Rename: map[string]string{"a": ""},
+		Clamp:  map[string]ClampRange{"max_tokens": {Min: &low, Max: &high}},
+	}
+	problems := strings.Join(rules.validate(), "; ")
+	for _, want := range []string{`drop: field "messages" cannot be transformed`, "rename: a has no target", "clamp: max_tokens min is greater than max"} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected %q, got %q", want, problems)
+		}
+	}
+}
+
+func TestTransformRulesRouting(t *testing.T) {
+	upstream := newFakeUpstream(t, contentChunk("ok"))
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, `
+port: 8080
+providers:
+  - name: up
+    url: `+upstream.URL+`
+    secret: sk
+    models: [glm-4.6]
+    transform:
+      drop: [parallel_tool_calls]
+      clamp:
+        max_tokens: {max: 8192}
+    modelTransforms:
+      "glm-*":
+        overrides:
+          enable_thinking: false
+`)
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, router := newRouter(t, config.Providers...)
+	body := `{"model":"[up]glm-4.6","stream":true,"max_tokens":100000,"parallel_tool_calls":true,"messages":[{"role":"user","content":"hi"}]}`
+	resp := postChat(t, context.Background(), router, body, nil)
+	resp.Body.Close()
+
+	_, forwarded := upstream.received()
+	if _, ok := forwarded["parallel_tool_calls"]; ok {
+		t.Error("Expected parallel_tool_calls to be dropped")
+	}
+	if forwarded["max_tokens"] != 8192.0 {
+		t.Errorf("Expected max_tokens clamped to 8192, got %v", forwarded["max_tokens"])
+	}
+	if forwarded["enable_thinking"] != false {
+		t.Errorf("Expected enable_thinking forced off, got %v", forwarded["enable_thinking"])
+	}
+}
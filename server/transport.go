@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	maxIdleConns          = 100
+	maxIdleConnsPerHost   = 16
+	idleConnTimeout       = 90 * time.Second
+)
+
+// sharedTransport serves every provider without custom proxy, TLS or
+// timeout settings, so that they share one connection pool.
+var sharedTransport = newBaseTransport()
+
+func newBaseTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   defaultConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// upstreamClient is the HTTP client used to reach one provider.
+type upstreamClient struct {
+	client      *http.Client
+	fingerprint string
+}
+
+// transportFingerprint identifies the settings that shape a provider's
+// transport, so clients can be kept across reloads that don't change them.
+// The CA bundle counts by content, so a rotated bundle at the same path is
+// picked up.
+func (p *Provider) transportFingerprint() string {
+	if p.Type == ProviderTypeMock {
+		mock, _ := json.Marshal(p.Mock)
+		return fmt.Sprintf("mock|%s|%s", mock, strings.Join(p.Models, ","))
+	}
+	return fmt.Sprintf("%s|%s@%s|%t|%s|%s", p.Proxy, p.CAFile, caFileDigest(p.CAFile), p.InsecureSkipVerify, p.ConnectTimeout, p.HeaderTimeout)
+}
+
+// caFileDigest returns a hash of the CA bundle, or "" if it cannot be read.
+func caFileDigest(caFile string) string {
+	if caFile == "" {
+		return ""
+	}
+	path, err := expandHome(caFile)
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *Provider) hasCustomTransport() bool {
+	return p.Proxy != "" || p.CAFile != "" || p.InsecureSkipVerify || p.ConnectTimeout > 0 || p.HeaderTimeout > 0
+}
+
+func newUpstreamClient(p *Provider) (*upstreamClient, error) {
+	uc := &upstreamClient{
+		client:      &http.Client{Transport: sharedTransport},
+		fingerprint: p.transportFingerprint(),
+	}
+	if p.Type == ProviderTypeMock {
+		uc.client = &http.Client{Transport: newMockTransport(p)}
+		return uc, nil
+	}
+	if !p.hasCustomTransport() {
+		return uc, nil
+	}
+
+	transport := sharedTransport.Clone()
+	if p.Proxy != "" {
+		proxyURL, err := parseProxyURL(p.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if p.CAFile != "" || p.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: p.InsecureSkipVerify}
+		if p.CAFile != "" {
+			pool, err := loadCertPool(p.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if p.ConnectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   p.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	transport.ResponseHeaderTimeout = p.HeaderTimeout
+
+	uc.client = &http.Client{Transport: transport}
+	return uc, nil
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy scheme must be http, https, socks5 or socks5h, got %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("proxy URL must include a host")
+	}
+	return proxyURL, nil
+}
+
+// loadCertPool returns the system roots plus the certificates in caFile.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	path, err := expandHome(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", caFile)
+	}
+	return pool, nil
+}
+
+// initClients rebuilds the per-provider clients, keeping those whose
+// transport settings did not change so their connection pools survive a
+// reload. Callers must hold s.mu.
+func (s *Server) initClients() {
+	clients := make(map[string]*upstreamClient)
+	for i := range s.config.Providers {
+		provider := &s.config.Providers[i]
+		if existing, ok := s.clients[provider.Name]; ok && existing.fingerprint == provider.transportFingerprint() {
+			clients[provider.Name] = existing
+			continue
+		}
+
+		uc, err := newUpstreamClient(provider)
+		if err != nil {
+			// Validate rejects these settings, so this only happens for
+			// configs that skipped validation.
+			Log(ComponentConfig).Error("invalid transport settings, using defaults", "provider", provider.Name, "error", err)
+			uc = &upstreamClient{client: &http.Client{Transport: sharedTransport}}
+		}
+		clients[provider.Name] = uc
+	}
+	s.clients = clients
+}
+
+func (s *Server) upstreamClient(providerName string) *upstreamClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if uc, ok := s.clients[providerName]; ok {
+		return uc
+	}
+	return &upstreamClient{client: &http.Client{Transport: sharedTransport}}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpstreamClientTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	get := func(p *Provider) error {
+		uc, err := newUpstreamClient(p)
+		if err != nil {
+			return err
+		}
+		resp, err := uc.client.Get(upstream.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(&Provider{}); err == nil {
+		t.Error("Expected default client to reject the test certificate")
+	}
+	if err := get(&Provider{CAFile: caFile}); err != nil {
+		t.Errorf("Expected client with CA bundle to connect, got: %v", err)
+	}
+	if err := get(&Provider{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("Expected insecure client to connect, got: %v", err)
+	}
+}
+
+func TestUpstreamClientCARotation(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	writeCA := func(comment string) {
+		if err := os.WriteFile(caFile, append([]byte(comment+"\n"), certPEM...), 0o600); err != nil {
+			t.Fatalf("Failed to write CA file: %v", err)
+		}
+	}
+	writeCA("# first bundle")
+	s := NewServer(&Config{Port: 8080, Providers: []Provider{{Name: "p", URL: upstream.URL, Secret: "sk", Models: []string{"m"}, CAFile: caFile}}}, "")
+	first := s.upstreamClient("p")
+
+	s.mu.Lock()
+	s.initClients()
+	s.mu.Unlock()
+	if s.upstreamClient("p") != first {
+		t.Error("Expected an unchanged CA bundle to keep the client")
+	}
+
+	writeCA("# rotated bundle")
+	s.mu.Lock()
+	s.initClients()
+	s.mu.Unlock()
+	if s.upstreamClient("p") == first {
+		t.Error("Expected a rotated CA bundle at the same path to rebuild the client")
+	}
+}
+
+func TestUpstreamClientProxy(t *testing.T) {
+	var proxied atomic.Bool
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute target URL.
+		if r.URL.Host == "upstream.invalid" {
+			proxied.Store(true)
+		}
+		fmt.Fprint(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	uc, err := newUpstreamClient(&Provider{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	resp, err := uc.client.Get("http://upstream.invalid/v1/models")
+	if err != nil {
+		t.Fatalf("Expected request through proxy to succeed, got: %v", err)
+	}
+	resp.Body.Close()
+
+	if !proxied.Load() {
+		t.Error("Expected request to go through the proxy")
+	}
+
+	if _, err := parseProxyURL("ftp://proxy:21"); err == nil {
+		t.Error("Expected ftp proxy to be rejected")
+	}
+}
@@ -1,27 +1,77 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Provider struct {
-	Name            string   `yaml:"name"`
-	URL             string   `yaml:"url"`
-	Secret          string   `yaml:"secret"`
-	Models          []string `yaml:"models"`
-	ConcurrentLimit int      `yaml:"concurrentLimit"`
+	Name             string                    `yaml:"name"`
+	Type             string                    `yaml:"type"`
+	Enabled          *bool                     `yaml:"enabled"`
+	Mock             *MockConfig               `yaml:"mock"`
+	Azure            *AzureConfig              `yaml:"azure"`
+	URL              string                    `yaml:"url"`
+	Secret           string                    `yaml:"secret"`
+	Models           []string                  `yaml:"models"`
+	ConcurrentLimit  int                       `yaml:"concurrentLimit"`
+	DiscoverModels   bool                      `yaml:"discoverModels"`
+	DiscoverInclude  []string                  `yaml:"discoverInclude"`
+	DiscoverExclude  []string                  `yaml:"discoverExclude"`
+	DiscoverInterval time.Duration             `yaml:"discoverInterval"`
+	ModelInfo        map[string]ModelInfo      `yaml:"modelInfo"`
+	CircuitBreaker   *CircuitBreakerConfig     `yaml:"circuitBreaker"`
+	HealthCheck      *HealthCheckConfig        `yaml:"healthCheck"`
+	Retry            *RetryConfig              `yaml:"retry"`
+	Transform        *TransformRules           `yaml:"transform"`
+	ModelTransforms  map[string]TransformRules `yaml:"modelTransforms"`
+	Headers          *HeaderPolicy             `yaml:"headers"`
+	Auth             *AuthConfig               `yaml:"auth"`
+	// IncludeUsage asks for a usage chunk at the end of every stream. It is
+	// off by default because some providers reject stream_options.
+	IncludeUsage *bool `yaml:"includeUsage"`
+
+	// Upstream connection settings.
+	Proxy              string        `yaml:"proxy"`
+	CAFile             string        `yaml:"caFile"`
+	InsecureSkipVerify bool          `yaml:"insecureSkipVerify"`
+	ConnectTimeout     time.Duration `yaml:"connectTimeout"`
+	HeaderTimeout      time.Duration `yaml:"headerTimeout"`
+	IdleStreamTimeout  time.Duration `yaml:"idleStreamTimeout"`
+	RequestTimeout     time.Duration `yaml:"requestTimeout"`
+	FirstTokenTimeout  time.Duration `yaml:"firstTokenTimeout"`
+
+	source string // file:line of the definition, for error messages
 }
 
 type Config struct {
-	Port      int        `yaml:"port"`
-	LogLevel  string     `yaml:"logLevel"`
-	Providers []Provider `yaml:"providers"`
+	Port       int               `yaml:"port"`
+	Listen     []ListenConfig    `yaml:"listen"`
+	LogLevel   string            `yaml:"logLevel"`
+	LogFormat  string            `yaml:"logFormat"`
+	LogLevels  map[string]string `yaml:"logLevels"`
+	LogPrompts bool              `yaml:"logPrompts"`
+	Timeouts   TimeoutsConfig    `yaml:"timeouts"`
+	Cache      *CacheConfig      `yaml:"cache"`
+	Fixtures   *FixturesConfig   `yaml:"fixtures"`
+	Prompts    []PromptPolicy    `yaml:"prompts"`
+	Context    *ContextConfig    `yaml:"context"`
+	Admin      *AdminConfig      `yaml:"admin"`
+	Providers  []Provider        `yaml:"providers"`
+
+	files []string // every file the config was loaded from
 }
 
 type Model struct {
-	ID     string `json:"id"`
-	Object string `json:"object"`
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	OwnedBy string `json:"owned_by,omitempty"`
+	Source  string `json:"source,omitempty"`
+	ModelInfo
 }
 
 type ModelsResponse struct {
@@ -66,8 +116,19 @@ func (r *ChatCompletionRequest) FromMap(data map[string]interface{}) error {
 				if role, ok := msgMap["role"].(string); ok {
 					message.Role = role
 				}
-				if content, ok := msgMap["content"].(string); ok {
+				switch content := msgMap["content"].(type) {
+				case string:
 					message.Content = content
+				case []interface{}:
+					message.Parts = content
+				}
+				for k, v := range msgMap {
+					if k != "role" && k != "content" {
+						if message.Extra == nil {
+							message.Extra = make(map[string]interface{})
+						}
+						message.Extra[k] = v
+					}
 				}
 				r.Messages = append(r.Messages, message)
 			}
@@ -86,8 +147,51 @@ func (r *ChatCompletionRequest) FromMap(data map[string]interface{}) error {
 }
 
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string                 `json:"role"`
+	Content string                 `json:"content"`
+	Parts   []interface{}          `json:"-"` // content parts, when content is an array
+	Extra   map[string]interface{} `json:"-"` // other fields such as name and tool_calls
+}
+
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	result := make(map[string]interface{}, len(m.Extra)+2)
+	for k, v := range m.Extra {
+		result[k] = v
+	}
+	result["role"] = m.Role
+	if m.Parts != nil {
+		result["content"] = m.Parts
+	} else {
+		result["content"] = m.Content
+	}
+	return json.Marshal(result)
+}
+
+// Text returns the message text, joining the text parts of multi-part content.
+func (m *ChatMessage) Text() string {
+	if m.Parts == nil {
+		return m.Content
+	}
+	var b strings.Builder
+	for _, part := range m.Parts {
+		if partMap, ok := part.(map[string]interface{}); ok && getString(partMap, "type") == "text" {
+			b.WriteString(getString(partMap, "text"))
+		}
+	}
+	return b.String()
+}
+
+// HasImage reports whether the message carries image content parts.
+func (m *ChatMessage) HasImage() bool {
+	for _, part := range m.Parts {
+		if partMap, ok := part.(map[string]interface{}); ok {
+			switch getString(partMap, "type") {
+			case "image_url", "input_image", "image":
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type ChatCompletionChoice struct {
@@ -104,10 +208,14 @@ type ChatMessageDelta struct {
 	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
 }
 
+// ToolCall is a tool call fragment in a streamed delta. Only the first
+// fragment of a call carries its ID, type and function name; later ones
+// append to the arguments of the call at the same index.
 type ToolCall struct {
-	ID       string                 `json:"id"`
-	Type     string                 `json:"type"`
-	Function map[string]interface{} `json:"function"`
+	Index    int                    `json:"index"`
+	ID       string                 `json:"id,omitempty"`
+	Type     string                 `json:"type,omitempty"`
+	Function map[string]interface{} `json:"function,omitempty"`
 }
 
 type ChatCompletionResponse struct {
@@ -121,12 +229,11 @@ type ChatCompletionResponse struct {
 }
 
 func (r *ChatCompletionResponse) FromMap(data map[string]interface{}) error {
+	// Some providers omit the id on later chunks or name it trace_id.
 	if id, ok := data["id"].(string); ok {
 		r.ID = id
 	} else if traceID, ok := data["trace_id"].(string); ok {
 		r.ID = traceID
-	} else {
-		panic("chunk missing field: id")
 	}
 
 	if object, ok := data["object"].(string); ok {
@@ -160,20 +267,6 @@ func (r *ChatCompletionResponse) FromMap(data map[string]interface{}) error {
 				// Handle delta
 				if delta, ok := choiceMap["delta"].(map[string]interface{}); ok {
 
-					// Handle tool calls incompatible if needed
-					if toolCalls, ok := delta["tool_calls"].([]interface{}); ok && len(toolCalls) > 0 {
-						toolCallsObj := make([]ToolCall, 1)
-						if call, ok := toolCalls[0].(map[string]interface{}); ok {
-							toolCallsObj[0].ID = r.ID
-							if callType, ok := call["type"].(string); ok {
-								toolCallsObj[0].Type = callType
-							}
-							if callFunction, ok := call["function"].(map[string]interface{}); ok {
-								toolCallsObj[0].Function = callFunction
-							}
-						}
-					}
-
 					deltaStruct := ChatMessageDelta{}
 					if role, ok := delta["role"].(string); ok {
 						deltaStruct.Role = role
@@ -181,6 +274,22 @@ func (r *ChatCompletionResponse) FromMap(data map[string]interface{}) error {
 					if content, ok := delta["content"].(string); ok {
 						deltaStruct.Content = content
 					}
+					if toolCalls, ok := delta["tool_calls"].([]interface{}); ok {
+						for _, item := range toolCalls {
+							call, ok := item.(map[string]interface{})
+							if !ok {
+								continue
+							}
+							toolCall := ToolCall{ID: getString(call, "id"), Type: getString(call, "type")}
+							if index, ok := call["index"].(float64); ok {
+								toolCall.Index = int(index)
+							}
+							if function, ok := call["function"].(map[string]interface{}); ok {
+								toolCall.Function = function
+							}
+							deltaStruct.ToolCalls = append(deltaStruct.ToolCalls, toolCall)
+						}
+					}
 					choiceStruct.Delta = &deltaStruct
 				}
 
@@ -189,6 +298,10 @@ func (r *ChatCompletionResponse) FromMap(data map[string]interface{}) error {
 		}
 	}
 
+	if usage, ok := data["usage"].(map[string]interface{}); ok {
+		r.Usage = usage
+	}
+
 	// Store extra fields
 	r.Extra = make(map[string]interface{})
 	for k, v := range data {
@@ -215,10 +328,7 @@ func (r *ChatCompletionResponse) ToMap() map[string]interface{} {
 		choiceMap["finish_reason"] = choice.FinishReason
 
 		if choice.Message != nil {
-			choiceMap["message"] = map[string]interface{}{
-				"role":    choice.Message.Role,
-				"content": choice.Message.Content,
-			}
+			choiceMap["message"] = choice.Message
 		}
 
 		if choice.Delta != nil {
@@ -252,7 +362,23 @@ type Server struct {
 	config     *Config
 	configPath string
 	mu         sync.RWMutex
+	reloadMu   sync.Mutex
+	overrides  ConfigOverrides
+	discovery  *modelDiscovery
+	breakers   map[string]*circuitBreaker
+	clients    map[string]*upstreamClient
 	limiters   map[string]chan struct{}
+	cache      *responseCache
+
+	providerStats map[string]*providerStats
+	active        activeRequests
+
+	stats    requestStats
+	draining atomic.Bool
+	// requestCtx is the base context of every request; cancelling it aborts
+	// requests still running when the drain window closes.
+	requestCtx     context.Context
+	cancelRequests context.CancelFunc
 }
 
 func NewServer(config *Config, configPath string) *Server {
@@ -260,21 +386,40 @@ func NewServer(config *Config, configPath string) *Server {
 		config:     config,
 		configPath: configPath,
 		limiters:   make(map[string]chan struct{}),
+		discovery:  newModelDiscovery(),
 	}
+	s.requestCtx, s.cancelRequests = context.WithCancel(context.Background())
 	s.initLimiters()
+	s.initBreakers()
+	s.initClients()
+	s.initCache()
+	s.initProviderStats()
 	return s
 }
 
+// initLimiters rebuilds the limiter map from the current config. Limiters of
+// providers whose concurrentLimit is unchanged are kept so that slots held by
+// in-flight requests stay accounted for. Callers must hold s.mu.
 func (s *Server) initLimiters() {
+	limiters := make(map[string]chan struct{})
 	for _, provider := range s.config.Providers {
-		if provider.ConcurrentLimit > 0 {
-			s.limiters[provider.Name] = make(chan struct{}, provider.ConcurrentLimit)
+		if provider.ConcurrentLimit <= 0 {
+			continue
+		}
+		if existing, ok := s.limiters[provider.Name]; ok && cap(existing) == provider.ConcurrentLimit {
+			limiters[provider.Name] = existing
+		} else {
+			limiters[provider.Name] = make(chan struct{}, provider.ConcurrentLimit)
 		}
 	}
+	s.limiters = limiters
 }
 
 func (s *Server) acquireSlot(providerName string) func() {
-	if limiter, ok := s.limiters[providerName]; ok {
+	s.mu.RLock()
+	limiter, ok := s.limiters[providerName]
+	s.mu.RUnlock()
+	if ok {
 		limiter <- struct{}{}
 		return func() { <-limiter }
 	}
@@ -286,7 +431,8 @@ func (s *Server) FindProvider(modelName string) *Provider {
 	defer s.mu.RUnlock()
 
 	for i, provider := range s.config.Providers {
-		if len(modelName) > len(provider.Name)+2 &&
+		if provider.enabled() &&
+			len(modelName) > len(provider.Name)+2 &&
 			modelName[0] == '[' &&
 			modelName[len(provider.Name)+1] == ']' &&
 			modelName[1:len(provider.Name)+1] == provider.Name {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkUnknownFields walks a parsed YAML tree alongside the Go type it decodes
+// into and reports every mapping key that has no matching field, with its
+// line and column. yaml.v3's KnownFields only reports the line of the first
+// unknown key, which is not enough for useful config errors.
+func checkUnknownFields(node *yaml.Node, t reflect.Type, filename string) []error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var errs []error
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			errs = append(errs, checkUnknownFields(child, t, filename)...)
+		}
+
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				fieldType, ok := fields[key.Value]
+				if !ok {
+					errs = append(errs, fmt.Errorf("%s:%d:%d: unknown field %q", filename, key.Line, key.Column, key.Value))
+					continue
+				}
+				errs = append(errs, checkUnknownFields(value, fieldType, filename)...)
+			}
+		case reflect.Map:
+			for i := 1; i < len(node.Content); i += 2 {
+				errs = append(errs, checkUnknownFields(node.Content[i], t.Elem(), filename)...)
+			}
+		}
+
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, child := range node.Content {
+				errs = append(errs, checkUnknownFields(child, t.Elem(), filename)...)
+			}
+		}
+	}
+	return errs
+}
+
+// yamlFields maps the YAML keys accepted by struct type t to their types,
+// following yaml.v3's naming rules including inline fields.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			for k, v := range yamlFields(field.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}